@@ -7,6 +7,8 @@ import (
 	"go/token"
 	"io"
 	"math"
+	"sort"
+	"strconv"
 	"unicode"
 )
 
@@ -24,14 +26,24 @@ type Builder struct {
 	cnt      uint
 	getter   bool
 	setter   bool
+	envelope bool
+	binary   bool
+	stream   bool
+	registry bool
+	view     bool
+	masks    bool
+	skip     bool
 	lineWrap int
 	imports  *ast.GenDecl
 	types    map[string]builtField
+	services map[string]*Service
+	svcDecls []ast.Decl
 }
 
 func NewBuilder() *Builder {
 	return &Builder{
 		types:    make(map[string]builtField),
+		services: make(map[string]*Service),
 		lineWrap: defWrap,
 	}
 }
@@ -46,10 +58,54 @@ func (e *Builder) Setter(f bool) *Builder {
 	return e
 }
 
+// Envelope prefixes the output of Encode by the schema hash, Decode fails
+// with ErrSchemaMismatch on data of another schema. Nested values are coded
+// by the unexported encode and decode, without the hash.
+func (e *Builder) Envelope(f bool) *Builder {
+	e.envelope = f
+	return e
+}
+
+// Binary emits MarshalBinary, UnmarshalBinary and AppendBinary.
+func (e *Builder) Binary(f bool) *Builder {
+	e.binary = f
+	return e
+}
+
+// Stream emits io.WriterTo and io.ReaderFrom, coding one value per frame as
+// WriteFrame and ReadFrame do.
+func (e *Builder) Stream(f bool) *Builder {
+	e.stream = f
+	return e
+}
+
+// Registry emits a TypeID method on every type and registers the types for
+// DecodeAny on init. Type ids default to a hash of the type name, see
+// Field.TypeID.
+func (e *Builder) Registry(f bool) *Builder {
+	e.registry = f
+	return e
+}
+
+func (e *Builder) encName() string {
+	if e.envelope {
+		return "encode"
+	}
+	return "Encode"
+}
+
+func (e *Builder) decName() string {
+	if e.envelope {
+		return "decode"
+	}
+	return "Decode"
+}
+
 func (e *Builder) SetLineWrap(wrap int) *Builder {
 	if wrap <= 0 {
-		e.lineWrap = defWrap
+		wrap = defWrap
 	}
+	e.lineWrap = wrap
 	return e
 }
 
@@ -100,6 +156,8 @@ func (e *Builder) encPrim(writer ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.
 				Body: &ast.BlockStmt{List: e.encField(writer, newIdx(ptr, i), s.sliceType)},
 			})
 		}
+	case s.typ.IsType(FieldStruct) && s.evolvable:
+		stmts = e.encTagged(writer, ptr, s)
 	case s.typ.IsType(FieldStruct):
 		for i := range s.strucFields {
 			bstmts := e.encField(writer, newSel(ptr, s.strucFields[i].strucName), s.strucFields[i].Field)
@@ -127,7 +185,7 @@ func (e *Builder) encPrim(writer ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.
 func (e *Builder) encField(writer ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.Stmt) {
 	if _, ok := e.types[s.typename]; ok {
 		stmts = append(stmts, newCallST(
-			newSel(ptr, "Encode"),
+			newSel(ptr, e.encName()),
 			writer,
 		))
 		return
@@ -137,6 +195,52 @@ func (e *Builder) encField(writer ast.Expr, ptr ast.Expr, s *Field) (stmts []ast
 	return
 }
 
+func (e *Builder) encTagged(writer ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.Stmt) {
+	for i := range s.strucFields {
+		field := s.strucFields[i]
+		wire := field.wire()
+		bstmts := []ast.Stmt{newCallST(
+			newSel(writer, "WriteTag"),
+			intLit(field.id),
+			wireSel(wire),
+		)}
+		if wire == WireBytes {
+			start := e.newIdent()
+			bstmts = append(bstmts, newDef(start, newCall(newSel(writer, "Pos"))))
+			bstmts = append(bstmts, e.encField(writer, newSel(ptr, field.strucName), field.Field)...)
+			bstmts = append(bstmts, newCallST(newSel(writer, "PrefixLen"), start))
+		} else {
+			bstmts = append(bstmts, e.encField(writer, newSel(ptr, field.strucName), field.Field)...)
+		}
+		if field.optional {
+			stmts = append(stmts, &ast.IfStmt{
+				Cond: newSel(ptr, newOpt(field.strucName)),
+				Body: &ast.BlockStmt{List: bstmts},
+			})
+		} else {
+			stmts = append(stmts, bstmts...)
+		}
+	}
+	if s.unknown {
+		stmts = append(stmts, newCallST(newSel(writer, "Write"), newSel(ptr, unknownName)))
+	}
+	stmts = append(stmts, newCallST(
+		newSel(writer, "WriteTag"),
+		intLit(0),
+		wireSel(WireEnd),
+	))
+	return
+}
+
+// readLen reads the length of the string or slice s, bounded by the data
+// unless the elements may take no bytes.
+func (e *Builder) readLen(reader ast.Expr, s *Field) ast.Expr {
+	if s.typ.IsType(FieldSlice) && s.sliceType.minSize() == 0 {
+		return newCall(newSel(reader, "ReadCount"), intLit(0))
+	}
+	return newCall(newSel(reader, "ReadLen"))
+}
+
 func (e *Builder) decPrim(reader ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.Stmt) {
 	switch {
 	case s.typ.IsPrimitive():
@@ -147,7 +251,7 @@ func (e *Builder) decPrim(reader ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.
 		))
 	case s.typ.IsType(FieldSlice) || s.typ.IsType(FieldString):
 		length := e.newIdent()
-		stmts = append(stmts, newDef(length, newCall(newSel(reader, "ReadLen"))))
+		stmts = append(stmts, newDef(length, e.readLen(reader, s)))
 		var bstmts []ast.Stmt
 		if s.sliceType.typ.IsPrimitive() {
 			hdr := e.newIdent()
@@ -188,6 +292,8 @@ func (e *Builder) decPrim(reader ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.
 			Cond: &ast.BinaryExpr{X: length, Op: token.GTR, Y: intLit(0)},
 			Body: &ast.BlockStmt{List: bstmts},
 		})
+	case s.typ.IsType(FieldStruct) && s.evolvable:
+		stmts = e.decTagged(reader, ptr, s)
 	case s.typ.IsType(FieldStruct):
 		for i := range s.strucFields {
 			bstmts := e.decField(reader, newSel(ptr, s.strucFields[i].strucName), s.strucFields[i].Field)
@@ -212,10 +318,92 @@ func (e *Builder) decPrim(reader ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.
 	return
 }
 
+func (e *Builder) decTagged(reader ast.Expr, ptr ast.Expr, s *Field) (stmts []ast.Stmt) {
+	id := e.newIdent()
+	wire := e.newIdent()
+	var clauses []ast.Stmt
+	for i := range s.strucFields {
+		field := s.strucFields[i]
+		var bstmts []ast.Stmt
+		if field.optional {
+			bstmts = append(bstmts, newAssign(newSel(ptr, newOpt(field.strucName)), "true"))
+		}
+		bstmts = append(bstmts, e.decWire(reader, newSel(ptr, field.strucName), field.Field)...)
+		clauses = append(clauses, &ast.CaseClause{
+			List: []ast.Expr{intLit(field.id)},
+			Body: []ast.Stmt{&ast.IfStmt{
+				Cond: newCall(newSel(reader, "ExpectWire"), wire, wireSel(field.wire())),
+				Body: &ast.BlockStmt{List: bstmts},
+			}},
+		})
+	}
+	// fields missing from the data are left zero, as on a new value
+	zero := e.newIdent()
+	stmts = append(stmts, &ast.DeclStmt{Decl: &ast.GenDecl{
+		Tok:   token.VAR,
+		Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{zero}, Type: e.typWrap(s)}},
+	}})
+	for _, field := range s.strucFields {
+		if field.optional {
+			stmts = append(stmts, newAssign(newSel(ptr, newOpt(field.strucName)), "false"))
+		}
+		stmts = append(stmts, newAssign(newSel(ptr, field.strucName), newSel(zero, field.strucName)))
+	}
+
+	var loop []ast.Stmt
+	skip := []ast.Stmt{newCallST(newSel(reader, "SkipWire"), wire)}
+	if s.unknown {
+		start := e.newIdent()
+		unknown := newSel(ptr, unknownName)
+		stmts = append(stmts, newAssign(unknown, &ast.SliceExpr{X: unknown, High: intLit(0)}))
+		loop = append(loop, newDef(start, newCall(newSel(reader, "Pos"))))
+		skip = append(skip, newAssign(unknown, &ast.CallExpr{
+			Fun: ast.NewIdent("append"),
+			Args: []ast.Expr{unknown, &ast.SliceExpr{
+				X:    newCall(newSel(reader, "Data")),
+				Low:  start,
+				High: newCall(newSel(reader, "Pos")),
+			}},
+			Ellipsis: 1,
+		}))
+	}
+	clauses = append(clauses, &ast.CaseClause{Body: skip})
+	stmts = append(stmts, &ast.ForStmt{
+		Body: &ast.BlockStmt{List: append(loop,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{id, wire},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{newCall(newSel(reader, "ReadTag"))},
+			},
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{X: wire, Op: token.EQL, Y: wireSel(WireEnd)},
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}},
+			},
+			&ast.SwitchStmt{
+				Tag:  id,
+				Body: &ast.BlockStmt{List: clauses},
+			},
+		)},
+	})
+	return
+}
+
+// decWire decodes a field of an evolvable struct. Values of WireBytes are
+// skipped to the end of their length, past what newer writers appended.
+func (e *Builder) decWire(reader, ptr ast.Expr, s *Field) []ast.Stmt {
+	if s.wire() != WireBytes {
+		return e.decField(reader, ptr, s)
+	}
+	end := e.newIdent()
+	stmts := []ast.Stmt{newDef(end, newCall(newSel(reader, "ReadEnd")))}
+	stmts = append(stmts, e.decField(reader, ptr, s)...)
+	return append(stmts, newCallST(newSel(reader, "SkipTo"), end))
+}
+
 func (e *Builder) decField(reader, ptr ast.Expr, s *Field) (stmts []ast.Stmt) {
 	if _, ok := e.types[s.typename]; ok {
 		stmts = append(stmts, newCallST(
-			newSel(ptr, "Decode"),
+			newSel(ptr, e.decName()),
 			reader,
 		))
 		return
@@ -248,10 +436,21 @@ func (e *Builder) typPrim(s *Field) ast.Expr {
 					Type:  newIdent(FieldBool.String()),
 				})
 			}
+			var tag *ast.BasicLit
+			if s.evolvable {
+				tag = &ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("`bstruct:\"id=%d\"`", field.id)}
+			}
 			fields = append(fields, &ast.Field{
 				Names:   []*ast.Ident{ast.NewIdent(field.strucName)},
 				Comment: e.commentGroup(field.comment),
 				Type:    e.typWrap(field.Field),
+				Tag:     tag,
+			})
+		}
+		if s.unknown {
+			fields = append(fields, &ast.Field{
+				Names: []*ast.Ident{ast.NewIdent(unknownName)},
+				Type:  &ast.ArrayType{Elt: newIdent("byte")},
 			})
 		}
 		return &ast.StructType{Fields: &ast.FieldList{List: fields}}
@@ -318,34 +517,33 @@ func (e *Builder) getFieldSetter(p *Field, el StructField) ast.Decl {
 	return setter
 }
 
+func (e *Builder) addImport(path string) {
+	value := strconv.Quote(path)
+	for _, spec := range e.imports.Specs {
+		if spec.(*ast.ImportSpec).Path.Value == value {
+			return
+		}
+	}
+	e.imports.Specs = append(e.imports.Specs, &ast.ImportSpec{
+		Path: &ast.BasicLit{
+			Kind:  token.STRING,
+			Value: value,
+		},
+	})
+}
+
 func (e *Builder) Process() {
 	e.cnt = 0
 
 	e.imports = &ast.GenDecl{
 		Tok: token.IMPORT,
 	}
-	e.imports.Specs = append(e.imports.Specs,
-		&ast.ImportSpec{
-			Path: &ast.BasicLit{
-				Kind:  token.STRING,
-				Value: "\"unsafe\"",
-			},
-		},
-		&ast.ImportSpec{
-			Path: &ast.BasicLit{
-				Kind:  token.STRING,
-				Value: "\"reflect\"",
-			},
-		},
-		&ast.ImportSpec{
-			Path: &ast.BasicLit{
-				Kind:  token.STRING,
-				Value: "\"github.com/xhebox/bstruct\"",
-			},
-		},
-	)
+	e.addImport("unsafe")
+	e.addImport("reflect")
+	e.addImport("github.com/xhebox/bstruct")
 
-	for name, el := range e.types {
+	for _, name := range e.names() {
+		el := e.types[name]
 		el.typ = ast.GenDecl{
 			Tok: token.TYPE,
 			Doc: e.commentGroup(el.field.comment),
@@ -358,21 +556,79 @@ func (e *Builder) Process() {
 		}
 
 		writer := ast.NewIdent("wt")
-		enc, val := e.getFunc(el.field, "Encode")
+		enc, val := e.getFunc(el.field, e.encName())
 		enc.Type.Params.List = append(enc.Type.Params.List, &ast.Field{Names: []*ast.Ident{writer}, Type: writerType})
 		enc.Body.List = append(enc.Body.List, e.encPrim(writer, val, el.field)...)
 		el.enc = *enc
 
 		reader := ast.NewIdent("rd")
-		dec, val := e.getFunc(el.field, "Decode")
+		dec, val := e.getFunc(el.field, e.decName())
 		dec.Type.Params.List = append(dec.Type.Params.List, &ast.Field{Names: []*ast.Ident{reader}, Type: readerType})
 		dec.Body.List = append(dec.Body.List, e.decPrim(reader, val, el.field)...)
 		el.dec = *dec
 
-		el.extra = e.extraDecl(el.field)
+		el.extra = e.hashDecl(el.field)
+		el.extra = append(el.extra, e.extraDecl(el.field)...)
 
 		e.types[name] = el
 	}
+
+	e.svcDecls = nil
+	for _, name := range e.serviceNames() {
+		e.addImport("context")
+		e.svcDecls = append(e.svcDecls, e.serviceDecl(e.services[name])...)
+	}
+}
+
+func (e *Builder) names() []string {
+	names := make([]string, 0, len(e.types))
+	for name := range e.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *Builder) hashDecl(el *Field) (decls []ast.Decl) {
+	hash := ast.NewIdent(fmt.Sprintf("%sSchemaHash", el.typename))
+	decls = append(decls, &ast.GenDecl{
+		Tok: token.CONST,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{hash},
+				Type:   newIdent("uint64"),
+				Values: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("%#016x", el.SchemaHash())}},
+			},
+		},
+	})
+
+	getter, _ := e.getFunc(el, "SchemaHash")
+	getter.Type.Results.List = append(getter.Type.Results.List, &ast.Field{Type: newIdent("uint64")})
+	getter.Body.List = append(getter.Body.List, &ast.ReturnStmt{Results: []ast.Expr{hash}})
+	decls = append(decls, getter)
+
+	if !e.envelope {
+		return
+	}
+
+	writer := ast.NewIdent("wt")
+	enc, _ := e.getFunc(el, "Encode")
+	enc.Type.Params.List = append(enc.Type.Params.List, &ast.Field{Names: []*ast.Ident{writer}, Type: writerType})
+	enc.Body.List = append(enc.Body.List,
+		newCallST(newSel(writer, "WriteHash"), hash),
+		newCallST(newSel("v", e.encName()), writer),
+	)
+
+	reader := ast.NewIdent("rd")
+	dec, _ := e.getFunc(el, "Decode")
+	dec.Type.Params.List = append(dec.Type.Params.List, &ast.Field{Names: []*ast.Ident{reader}, Type: readerType})
+	dec.Body.List = append(dec.Body.List, &ast.IfStmt{
+		Cond: newCall(newSel(reader, "CheckHash"), hash),
+		Body: &ast.BlockStmt{List: []ast.Stmt{newCallST(newSel("v", e.decName()), reader)}},
+	})
+
+	decls = append(decls, enc, dec)
+	return
 }
 
 func (e *Builder) extraDecl(el *Field) (decls []ast.Decl) {
@@ -388,6 +644,31 @@ func (e *Builder) extraDecl(el *Field) (decls []ast.Decl) {
 		}
 	}
 
+	if e.binary {
+		decls = append(decls, e.binaryDecl(el)...)
+	}
+
+	if e.stream {
+		e.addImport("io")
+		decls = append(decls, e.streamDecl(el)...)
+	}
+
+	if e.registry {
+		decls = append(decls, e.registryDecl(el)...)
+	}
+
+	if e.skip || e.view || e.masks {
+		decls = append(decls, e.skipDecl(el)...)
+	}
+
+	if e.view && el.typ.IsType(FieldStruct) {
+		decls = append(decls, e.viewDecl(el)...)
+	}
+
+	if e.masks && el.typ.IsType(FieldStruct) {
+		decls = append(decls, e.maskDecl(el)...)
+	}
+
 	return
 }
 
@@ -401,11 +682,12 @@ func (e *Builder) Print(buf io.Writer, pak string) error {
 		Name:  ast.NewIdent(pak),
 		Decls: []ast.Decl{e.imports},
 	}
-	for _, e := range e.types {
-		el := e
+	for _, name := range e.names() {
+		el := e.types[name]
 		file.Decls = append(file.Decls, &el.typ, &el.enc, &el.dec)
 		file.Decls = append(file.Decls, el.extra...)
 	}
+	file.Decls = append(file.Decls, e.svcDecls...)
 	if err := cfg.Fprint(buf, ts, file); err != nil {
 		return err
 	}
@@ -415,6 +697,16 @@ func (e *Builder) Print(buf io.Writer, pak string) error {
 	return nil
 }
 
+// declNames tracks the owners of generated names, to catch collisions.
+type declNames map[string]string
+
+func (n declNames) claim(name, owner string) {
+	if prev, ok := n[name]; ok {
+		panic(fmt.Sprintf("%s of %s is taken by %s", name, owner, prev))
+	}
+	n[name] = owner
+}
+
 func (e *Builder) commentGroup(comment string) *ast.CommentGroup {
 	if len(comment) == 0 {
 		return nil
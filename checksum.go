@@ -0,0 +1,33 @@
+package bstruct
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var ErrChecksum = errors.New("bstruct: checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteChecksum appends the little-endian CRC32C of everything written so
+// far, to be verified by NewCheckedReader.
+func (w *Writer) WriteChecksum() {
+	sum := crc32.Checksum(w.Data(), castagnoli)
+	w.grow(4)
+	binary.LittleEndian.PutUint32(w.data[w.pos:], sum)
+	w.pos += 4
+}
+
+// NewCheckedReader verifies the checksum trailing data, as written by
+// WriteChecksum, and returns a Reader of the data before it.
+func NewCheckedReader(data []byte) (*Reader, error) {
+	if len(data) < 4 {
+		return nil, ErrShortData
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, ErrChecksum
+	}
+	return NewReader(body), nil
+}
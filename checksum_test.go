@@ -0,0 +1,30 @@
+package bstruct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	wt := NewWriter()
+	wt.WriteLen(3)
+	wt.Write([]byte("abc"))
+	wt.WriteChecksum()
+	data := wt.Data()
+	require.Len(t, data, 8)
+
+	rd, err := NewCheckedReader(data)
+	require.NoError(t, err)
+	require.Equal(t, 3, rd.ReadLen())
+	require.Equal(t, []byte("abc"), rd.Data()[rd.Pos():])
+
+	for i := range data {
+		data[i] ^= 0x10
+		_, err = NewCheckedReader(data)
+		require.ErrorIs(t, err, ErrChecksum)
+		data[i] ^= 0x10
+	}
+	_, err = NewCheckedReader(data[:3])
+	require.ErrorIs(t, err, ErrShortData)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xhebox/bstruct"
+)
+
+// compat lists the changes between two schemas, and fails on breaking ones.
+func compat(args []string) error {
+	fs := flag.NewFlagSet("compat", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only print breaking changes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bstruct compat [-q] old.json new.json\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	prev, err := loadSchema(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	next, err := loadSchema(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	for _, change := range bstruct.Compare(prev, next) {
+		if change.Breaking {
+			err = bstruct.ErrBreaking
+		}
+		if change.Breaking || !*quiet {
+			fmt.Println(change)
+		}
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+// Command bstruct works with JSON schema descriptors, as written by
+// json.Marshal of a bstruct.Builder.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/xhebox/bstruct"
+)
+
+var commands = map[string]func(args []string) error{
+	"compat": compat,
+	"decode": decode,
+	"dump":   dump,
+	"encode": encode,
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: bstruct <command> [flags] [args]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\t%s\n", name)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadType loads the schema and looks up the named type in it.
+func loadType(path, name string) (*bstruct.Builder, *bstruct.Field, error) {
+	if path == "" || name == "" {
+		return nil, nil, fmt.Errorf("both -schema and -type are required")
+	}
+	enc, err := loadSchema(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := enc.Lookup(name)
+	if f == nil {
+		return nil, nil, fmt.Errorf("%s: no type %s", path, name)
+	}
+	return enc, f, nil
+}
+
+// readInput reads the only argument as file, or stdin without arguments.
+func readInput(fs *flag.FlagSet) ([]byte, error) {
+	switch fs.NArg() {
+	case 0:
+		return io.ReadAll(os.Stdin)
+	case 1:
+		return os.ReadFile(fs.Arg(0))
+	default:
+		fs.Usage()
+		os.Exit(2)
+		return nil, nil
+	}
+}
+
+func loadSchema(path string) (*bstruct.Builder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	enc := bstruct.NewBuilder()
+	if err := json.Unmarshal(data, enc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return enc, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xhebox/bstruct"
+)
+
+// decode prints a binary message as JSON.
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	schema := fs.String("schema", "", "JSON schema descriptor")
+	typ := fs.String("type", "", "registered type of the message")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bstruct decode -schema s.json -type T [in.bin]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	enc, f, err := loadType(*schema, *typ)
+	if err != nil {
+		return err
+	}
+	data, err := readInput(fs)
+	if err != nil {
+		return err
+	}
+
+	rd := bstruct.NewReader(data)
+	if enc.IsEnvelope() && !rd.CheckHash(f.SchemaHash()) {
+		return rd.Err()
+	}
+	v, err := bstruct.DecodeValue(rd, f)
+	if err != nil {
+		return fmt.Errorf("at offset %#x: %w", rd.Pos(), err)
+	}
+	if rem := len(data) - rd.Pos(); rem > 0 {
+		return fmt.Errorf("%d trailing bytes after offset %#x", rem, rd.Pos())
+	}
+
+	out, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", out)
+	return err
+}
+
+// encode writes the binary form of a JSON message.
+func encode(args []string) error {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	schema := fs.String("schema", "", "JSON schema descriptor")
+	typ := fs.String("type", "", "registered type of the message")
+	out := fs.String("o", "", "output file, stdout by default")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bstruct encode -schema s.json -type T [-o out.bin] [in.json]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	enc, f, err := loadType(*schema, *typ)
+	if err != nil {
+		return err
+	}
+	data, err := readInput(fs)
+	if err != nil {
+		return err
+	}
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	wt := bstruct.NewWriter()
+	if enc.IsEnvelope() {
+		wt.WriteHash(f.SchemaHash())
+	}
+	if err := bstruct.EncodeValue(wt, f, v); err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(wt.Data())
+		return err
+	}
+	return os.WriteFile(*out, wt.Data(), 0644)
+}
+
+// dump explains the wire layout of a binary message.
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	schema := fs.String("schema", "", "JSON schema descriptor")
+	typ := fs.String("type", "", "registered type of the message")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bstruct dump -schema s.json -type T [in.bin]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	enc, f, err := loadType(*schema, *typ)
+	if err != nil {
+		return err
+	}
+	data, err := readInput(fs)
+	if err != nil {
+		return err
+	}
+	return bstruct.Dump(os.Stdout, data, f, enc.IsEnvelope())
+}
@@ -0,0 +1,363 @@
+package bstruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+var ErrCustom = errors.New("bstruct: custom fields can not be coded at runtime")
+
+var typeFields sync.Map
+
+// Marshal encodes v by reflection, the output is byte-identical to the
+// Encode method generated for the equivalent Field tree.
+func Marshal(v any) ([]byte, error) {
+	rv, err := addressable(v)
+	if err != nil {
+		return nil, err
+	}
+	f, err := cachedField(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	return marshalValue(f, rv)
+}
+
+// Unmarshal decodes data into the struct pointed by v. As with the generated
+// Decode, strings and primitive slices alias data.
+func Unmarshal(data []byte, v any) error {
+	rv, err := pointee(v)
+	if err != nil {
+		return err
+	}
+	f, err := cachedField(rv.Type())
+	if err != nil {
+		return err
+	}
+	return unmarshalValue(f, data, rv)
+}
+
+// MarshalField is like Marshal, but walks the given schema instead of one
+// derived from the type of v. Struct fields are matched by name.
+func MarshalField(f *Field, v any) ([]byte, error) {
+	rv, err := addressable(v)
+	if err != nil {
+		return nil, err
+	}
+	return marshalValue(f, rv)
+}
+
+func UnmarshalField(f *Field, data []byte, v any) error {
+	rv, err := pointee(v)
+	if err != nil {
+		return err
+	}
+	return unmarshalValue(f, data, rv)
+}
+
+func marshalValue(f *Field, v reflect.Value) ([]byte, error) {
+	wt := NewWriter()
+	if err := encodeValue(wt, f, v); err != nil {
+		return nil, err
+	}
+	return wt.Data(), nil
+}
+
+func unmarshalValue(f *Field, data []byte, v reflect.Value) error {
+	rd := NewReader(data)
+	if err := decodeValue(rd, f, v); err != nil {
+		return err
+	}
+	return rd.Err()
+}
+
+func addressable(v any) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return rv, fmt.Errorf("bstruct: can not marshal nil")
+	}
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return rv, fmt.Errorf("bstruct: can not marshal nil %s", rv.Type())
+		}
+		return rv.Elem(), nil
+	}
+	p := reflect.New(rv.Type())
+	p.Elem().Set(rv)
+	return p.Elem(), nil
+}
+
+func pointee(v any) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return rv, fmt.Errorf("bstruct: can not unmarshal into %T", v)
+	}
+	return rv.Elem(), nil
+}
+
+func cachedField(t reflect.Type) (*Field, error) {
+	if f, ok := typeFields.Load(t); ok {
+		return f.(*Field), nil
+	}
+	f, err := typeField(t, nil, make(map[reflect.Type]*Field))
+	if err != nil {
+		return nil, err
+	}
+	typeFields.Store(t, f)
+	return f, nil
+}
+
+func checkKind(f *Field, v reflect.Value) error {
+	if f.typ.IsType(FieldCustom) {
+		return ErrCustom
+	}
+	if f.typ.kind() != v.Kind() && !(isInteger(f.typ) && isPlatformInt(v.Kind())) {
+		return fmt.Errorf("bstruct: can not code %s as %s", v.Type(), f.typ)
+	}
+	if f.typ.IsType(FieldSlice) && f.sliceType.typ.IsPrimitive() {
+		return checkKind(f.sliceType, reflect.New(v.Type().Elem()).Elem())
+	}
+	return nil
+}
+
+// structField returns a settable X of struct v, unexported or not.
+func structField(v reflect.Value, name string) (reflect.Value, error) {
+	sf, ok := v.Type().FieldByName(name)
+	if !ok || len(sf.Index) != 1 {
+		return reflect.Value{}, fmt.Errorf("bstruct: %s has no field %s", v.Type(), name)
+	}
+	return reflect.NewAt(sf.Type, unsafe.Pointer(v.Field(sf.Index[0]).UnsafeAddr())).Elem(), nil
+}
+
+func isPlatformInt(k reflect.Kind) bool {
+	return k == reflect.Int || k == reflect.Uint || k == reflect.Uintptr
+}
+
+// presence returns the flag of the optional sf. Structs declared without
+// one, e.g. through the optional tag, treat a non-zero value as present.
+func presence(v, fv reflect.Value, sf StructField) reflect.Value {
+	if has, err := structField(v, newOpt(sf.strucName)); err == nil {
+		return has
+	}
+	has := reflect.New(reflect.TypeOf(false)).Elem()
+	has.SetBool(!fv.IsZero())
+	return has
+}
+
+func encodeValue(w *Writer, f *Field, v reflect.Value) error {
+	if err := checkKind(f, v); err != nil {
+		return err
+	}
+
+	switch {
+	case f.typ.IsPrimitive():
+		if v.Kind() != f.typ.kind() {
+			v = v.Convert(f.typ.reflectType())
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p.Elem()
+		}
+		w.Copy(unsafe.Pointer(v.UnsafeAddr()), int(f.typ.Size()))
+	case f.typ.IsType(FieldString):
+		w.WriteLen(v.Len())
+		if v.Len() > 0 {
+			hdr := (*reflect.StringHeader)(unsafe.Pointer(v.UnsafeAddr()))
+			w.Copy(unsafe.Pointer(hdr.Data), v.Len())
+		}
+	case f.typ.IsType(FieldSlice):
+		w.WriteLen(v.Len())
+		if f.sliceType.typ.IsPrimitive() && v.Type().Elem().Kind() == f.sliceType.typ.kind() {
+			if v.Len() > 0 {
+				w.Copy(v.UnsafePointer(), int(f.sliceType.typ.Size())*v.Len())
+			}
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(w, f.sliceType, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case f.typ.IsType(FieldStruct) && f.evolvable:
+		return encodeTagged(w, f, v)
+	case f.typ.IsType(FieldStruct):
+		for _, sf := range f.strucFields {
+			fv, err := structField(v, sf.strucName)
+			if err != nil {
+				return err
+			}
+			if sf.optional {
+				has := presence(v, fv, sf)
+				if err := encodeValue(w, New(FieldBool), has); err != nil {
+					return err
+				}
+				if !has.Bool() {
+					continue
+				}
+			}
+			if err := encodeValue(w, sf.Field, fv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeValue(r *Reader, f *Field, v reflect.Value) error {
+	if err := checkKind(f, v); err != nil {
+		return err
+	}
+
+	switch {
+	case f.typ.IsPrimitive():
+		if v.Kind() != f.typ.kind() {
+			p := reflect.New(f.typ.reflectType())
+			r.Copy(p.UnsafePointer(), int(f.typ.Size()))
+			v.Set(p.Elem().Convert(v.Type()))
+			break
+		}
+		r.Copy(unsafe.Pointer(v.UnsafeAddr()), int(f.typ.Size()))
+	case f.typ.IsType(FieldString):
+		length := r.ReadLen()
+		v.SetString("")
+		if length > 0 {
+			if data := r.Read(length); data != 0 {
+				hdr := (*reflect.StringHeader)(unsafe.Pointer(v.UnsafeAddr()))
+				hdr.Data = data
+				hdr.Len = length
+			}
+		}
+	case f.typ.IsType(FieldSlice):
+		length := r.ReadCount(f.sliceType.minSize())
+		v.Set(reflect.Zero(v.Type()))
+		if length == 0 {
+			break
+		}
+		if f.sliceType.typ.IsPrimitive() && v.Type().Elem().Kind() == f.sliceType.typ.kind() {
+			if data := r.Read(int(f.sliceType.typ.Size()) * length); data != 0 {
+				hdr := (*reflect.SliceHeader)(unsafe.Pointer(v.UnsafeAddr()))
+				hdr.Data = data
+				hdr.Len = length
+				hdr.Cap = length
+			}
+			break
+		}
+		v.Set(reflect.MakeSlice(v.Type(), length, length))
+		for i := 0; i < length && r.err == nil; i++ {
+			if err := decodeValue(r, f.sliceType, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case f.typ.IsType(FieldStruct) && f.evolvable:
+		return decodeTagged(r, f, v)
+	case f.typ.IsType(FieldStruct):
+		for _, sf := range f.strucFields {
+			fv, err := structField(v, sf.strucName)
+			if err != nil {
+				return err
+			}
+			if sf.optional {
+				has := presence(v, fv, sf)
+				if err := decodeValue(r, New(FieldBool), has); err != nil {
+					return err
+				}
+				if !has.Bool() {
+					continue
+				}
+			}
+			if err := decodeValue(r, sf.Field, fv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func encodeTagged(w *Writer, f *Field, v reflect.Value) error {
+	for _, sf := range f.strucFields {
+		fv, err := structField(v, sf.strucName)
+		if err != nil {
+			return err
+		}
+		if sf.optional && !presence(v, fv, sf).Bool() {
+			continue
+		}
+		wire := sf.wire()
+		w.WriteTag(int(sf.id), wire)
+		start := w.Pos()
+		if err := encodeValue(w, sf.Field, fv); err != nil {
+			return err
+		}
+		if wire == WireBytes {
+			w.PrefixLen(start)
+		}
+	}
+	if f.unknown {
+		unknown, err := structField(v, unknownName)
+		if err != nil {
+			return err
+		}
+		w.Write(unknown.Bytes())
+	}
+	w.WriteTag(0, WireEnd)
+	return nil
+}
+
+func decodeTagged(r *Reader, f *Field, v reflect.Value) error {
+	var unknown reflect.Value
+	if f.unknown {
+		var err error
+		if unknown, err = structField(v, unknownName); err != nil {
+			return err
+		}
+		unknown.SetBytes(unknown.Bytes()[:0])
+	}
+	// fields missing from the data are left zero, as on a new value
+	for _, sf := range f.strucFields {
+		fv, err := structField(v, sf.strucName)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.Zero(fv.Type()))
+		if has, err := structField(v, newOpt(sf.strucName)); sf.optional && err == nil {
+			has.SetBool(false)
+		}
+	}
+	for {
+		start := r.Pos()
+		id, wire := r.ReadTag()
+		if wire == WireEnd {
+			return nil
+		}
+		sf, ok := f.fieldByID(uint(id))
+		if !ok {
+			r.SkipWire(wire)
+			if f.unknown {
+				unknown.SetBytes(append(unknown.Bytes(), r.Data()[start:r.Pos()]...))
+			}
+			continue
+		}
+		if !r.ExpectWire(wire, sf.wire()) {
+			continue
+		}
+		fv, err := structField(v, sf.strucName)
+		if err != nil {
+			return err
+		}
+		if has, err := structField(v, newOpt(sf.strucName)); sf.optional && err == nil {
+			has.SetBool(true)
+		}
+		end := -1
+		if wire == WireBytes {
+			end = r.ReadEnd()
+		}
+		if err := decodeValue(r, sf.Field, fv); err != nil {
+			return err
+		}
+		if end >= 0 {
+			r.SkipTo(end)
+		}
+	}
+}
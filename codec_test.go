@@ -0,0 +1,140 @@
+package bstruct
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type codecInner struct {
+	A int16
+	B string
+}
+
+type codecStruct struct {
+	A   bool
+	B   []uint32
+	C   codecInner
+	D   []codecInner
+	__E bool
+	E   float64
+	f   []string
+}
+
+func TestCodec(t *testing.T) {
+	v := &codecStruct{
+		A:   true,
+		B:   []uint32{1, 2, 3},
+		C:   codecInner{A: -3, B: "x"},
+		D:   []codecInner{{A: 1}, {B: "yy"}},
+		__E: true,
+		E:   1.5,
+		f:   []string{"a", ""},
+	}
+	data, err := Marshal(v)
+	require.NoError(t, err)
+
+	g := &codecStruct{}
+	require.NoError(t, Unmarshal(data, g))
+	require.Equal(t, v, g)
+
+	for i := 0; i < len(data); i++ {
+		require.Error(t, Unmarshal(data[:i], &codecStruct{}))
+	}
+
+	f := New(FieldStruct).
+		Add("A", "", false, New(FieldBool)).
+		Add("B", "", false, NewSlice(New(FieldUint32)))
+	data, err = MarshalField(f, v)
+	require.NoError(t, err)
+	require.Len(t, data, 1+1+12)
+
+	_, err = Marshal(struct{ A map[int]int }{})
+	require.Error(t, err)
+	require.Error(t, UnmarshalField(f, data, &codecInner{}))
+
+	f = New(FieldStruct).Add("A", "", false, NewSlice(New(FieldInt32)))
+	ints := struct{ A []int }{[]int{1, -2, 3}}
+	data, err = MarshalField(f, ints)
+	require.NoError(t, err)
+	require.Len(t, data, 1+12)
+	i32 := struct{ A []int32 }{}
+	require.NoError(t, UnmarshalField(f, data, &i32))
+	require.Equal(t, []int32{1, -2, 3}, i32.A)
+	ints.A = nil
+	require.NoError(t, UnmarshalField(f, data, &ints))
+	require.Equal(t, []int{1, -2, 3}, ints.A)
+
+	// elements encoded to no bytes are not bounded by the data
+	empty := &struct{ A []struct{} }{A: make([]struct{}, 3)}
+	data, err = Marshal(empty)
+	require.NoError(t, err)
+	require.Equal(t, []byte{6}, data)
+	empty.A = nil
+	require.NoError(t, Unmarshal(data, empty))
+	require.Len(t, empty.A, 3)
+	f, err = cachedField(reflect.TypeOf(*empty))
+	require.NoError(t, err)
+	dyn, err := DecodeValue(NewReader(data), f)
+	require.NoError(t, err)
+	require.Len(t, dyn.(map[string]any)["A"], 3)
+}
+
+type evolveV1 struct {
+	A uint32 `bstruct:"id=1"`
+	B string `bstruct:"id=2"`
+	C []bool `bstruct:"id=3"`
+}
+
+type evolveV2 struct {
+	C   []bool `bstruct:"id=3"`
+	A   uint32 `bstruct:"id=1"`
+	__D bool
+	D   codecInner `bstruct:"id=4"`
+	E   int64      `bstruct:"id=5"`
+}
+
+type codecGrown struct {
+	A int16
+	B string
+	C uint64
+}
+
+type evolveV3 struct {
+	A uint32     `bstruct:"id=1"`
+	D codecGrown `bstruct:"id=4"`
+}
+
+func TestEvolvable(t *testing.T) {
+	v1 := &evolveV1{A: 1, B: "old", C: []bool{true}}
+	data, err := Marshal(v1)
+	require.NoError(t, err)
+	v2 := &evolveV2{}
+	require.NoError(t, Unmarshal(data, v2))
+	require.Equal(t, &evolveV2{A: 1, C: []bool{true}}, v2)
+
+	v2 = &evolveV2{A: 2, __D: true, D: codecInner{A: 3, B: "new"}, E: -1}
+	data, err = Marshal(v2)
+	require.NoError(t, err)
+	v1 = &evolveV1{}
+	require.NoError(t, Unmarshal(data, v1))
+	require.Equal(t, &evolveV1{A: 2}, v1)
+
+	data, err = Marshal(&evolveV3{A: 7, D: codecGrown{A: 3, B: "x", C: 9}})
+	require.NoError(t, err)
+	require.NoError(t, Unmarshal(data, v2))
+	require.Equal(t, &evolveV2{A: 7, __D: true, D: codecInner{A: 3, B: "x"}}, v2)
+	f2, err := cachedField(reflect.TypeOf(*v2))
+	require.NoError(t, err)
+	dyn, err := DecodeValue(NewReader(data), f2)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"A": int16(3), "B": "x"}, dyn.(map[string]any)["D"])
+	require.Error(t, Unmarshal([]byte{4<<3 | WireBytes, 1, 3, 0, 0}, v2))
+
+	f := New(FieldStruct).Evolvable().Add("A", "", false, New(FieldBool)).Reserve(2)
+	require.Panics(t, func() { f.AddID(2, "B", "", false, New(FieldBool)) })
+	require.Panics(t, func() { f.AddID(1, "B", "", false, New(FieldBool)) })
+	f.Add("C", "", false, New(FieldBool))
+	require.Equal(t, uint(3), f.strucFields[1].id)
+}
@@ -0,0 +1,174 @@
+package bstruct
+
+import (
+	"errors"
+	"fmt"
+	"go/types"
+	"strings"
+)
+
+var ErrBreaking = errors.New("bstruct: breaking schema change")
+
+// Change is a difference between two versions of a schema. Breaking changes
+// make data of one version undecodable, or silently misdecoded, by the other.
+type Change struct {
+	Path     string
+	Message  string
+	Breaking bool
+}
+
+func (c Change) String() string {
+	if c.Breaking {
+		return fmt.Sprintf("breaking: %s: %s", c.Path, c.Message)
+	}
+	return fmt.Sprintf("safe: %s: %s", c.Path, c.Message)
+}
+
+// Compare lists the changes of the types registered to next against those
+// registered to prev.
+func Compare(prev, next *Builder) []Change {
+	c := &comparer{seen: make(map[[2]*Field]bool)}
+	for _, name := range prev.names() {
+		el, ok := next.types[name]
+		if !ok {
+			c.add(name, true, "type removed")
+			continue
+		}
+		c.field(name, prev.types[name].field, el.field)
+	}
+	for _, name := range next.names() {
+		if _, ok := prev.types[name]; !ok {
+			c.add(name, false, "type added")
+		}
+	}
+	return c.changes
+}
+
+// CheckCompat fails with ErrBreaking if any change from prev to next breaks.
+func CheckCompat(prev, next *Builder) error {
+	var breaking []string
+	for _, change := range Compare(prev, next) {
+		if change.Breaking {
+			breaking = append(breaking, change.String())
+		}
+	}
+	if len(breaking) > 0 {
+		return fmt.Errorf("%w:\n%s", ErrBreaking, strings.Join(breaking, "\n"))
+	}
+	return nil
+}
+
+type comparer struct {
+	seen    map[[2]*Field]bool
+	changes []Change
+}
+
+func (c *comparer) add(path string, breaking bool, format string, args ...any) {
+	c.changes = append(c.changes, Change{
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+		Breaking: breaking,
+	})
+}
+
+func (c *comparer) field(path string, a, b *Field) {
+	if c.seen[[2]*Field{a, b}] {
+		return
+	}
+	c.seen[[2]*Field{a, b}] = true
+
+	if (a.typeID != 0 || b.typeID != 0) && a.messageID() != b.messageID() {
+		c.add(path, true, "type id changed from %d to %d", a.messageID(), b.messageID())
+	}
+
+	if a.typ != b.typ {
+		c.add(path, true, "type changed from %s to %s", a.typ, b.typ)
+		return
+	}
+
+	switch {
+	case a.typ.IsType(FieldSlice):
+		c.field(path+"[]", a.sliceType, b.sliceType)
+	case a.typ.IsType(FieldCustom):
+		if x, y := types.ExprString(a.custyp), types.ExprString(b.custyp); x != y {
+			c.add(path, true, "custom type changed from %s to %s", x, y)
+		}
+	case a.typ.IsType(FieldStruct):
+		if a.evolvable != b.evolvable {
+			c.add(path, true, "evolvable changed from %t to %t", a.evolvable, b.evolvable)
+			return
+		}
+		if a.unknown != b.unknown {
+			c.add(path, false, "keeping unknown fields changed from %t to %t", a.unknown, b.unknown)
+		}
+		if a.evolvable {
+			c.tagged(path, a, b)
+		} else {
+			c.positional(path, a, b)
+		}
+	}
+}
+
+func (c *comparer) positional(path string, a, b *Field) {
+	for i, fa := range a.strucFields {
+		if i >= len(b.strucFields) {
+			c.add(path+"."+fa.strucName, true, "field removed")
+			continue
+		}
+		fb := b.strucFields[i]
+		if fa.strucName != fb.strucName {
+			if j := fieldIndex(b, fa.strucName); j >= 0 {
+				c.add(path+"."+fa.strucName, true, "field moved from position %d to %d", i, j)
+			} else {
+				c.add(path+"."+fa.strucName, false, "field renamed to %s", fb.strucName)
+			}
+		}
+		if fa.optional != fb.optional {
+			c.add(path+"."+fa.strucName, true, "optional changed from %t to %t", fa.optional, fb.optional)
+		}
+		c.field(path+"."+fa.strucName, fa.Field, fb.Field)
+	}
+	for i := len(a.strucFields); i < len(b.strucFields); i++ {
+		c.add(path+"."+b.strucFields[i].strucName, true, "field added to a positional struct")
+	}
+}
+
+func (c *comparer) tagged(path string, a, b *Field) {
+	for _, fa := range a.strucFields {
+		fb, ok := b.fieldByID(fa.id)
+		if !ok {
+			if b.isReserved(fa.id) {
+				c.add(path+"."+fa.strucName, false, "field %d removed and reserved", fa.id)
+			} else {
+				c.add(path+"."+fa.strucName, true, "field %d removed without being reserved", fa.id)
+			}
+			continue
+		}
+		if fa.strucName != fb.strucName {
+			c.add(path+"."+fa.strucName, false, "field %d renamed to %s", fa.id, fb.strucName)
+		}
+		if fa.optional != fb.optional {
+			c.add(path+"."+fb.strucName, false, "optional changed from %t to %t", fa.optional, fb.optional)
+		}
+		c.field(path+"."+fb.strucName, fa.Field, fb.Field)
+	}
+	for _, fb := range b.strucFields {
+		if _, ok := a.fieldByID(fb.id); ok {
+			continue
+		}
+		if a.isReserved(fb.id) {
+			c.add(path+"."+fb.strucName, true, "field %d reuses a reserved id", fb.id)
+		} else {
+			c.add(path+"."+fb.strucName, false, "field %d added", fb.id)
+		}
+	}
+}
+
+func fieldIndex(s *Field, name string) int {
+	for i, f := range s.strucFields {
+		if f.strucName == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,69 @@
+package bstruct
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	prev := NewBuilder()
+	New(FieldStruct).Reg(prev, "Pos").
+		Add("A", "", false, New(FieldBool)).
+		Add("B", "", false, NewString()).
+		Add("C", "", true, New(FieldInt8))
+	New(FieldStruct).Reg(prev, "Tagged").Evolvable().
+		Add("A", "", false, New(FieldBool)).
+		Add("B", "", false, NewString()).
+		Add("C", "", false, New(FieldInt8))
+	New(FieldBool).Reg(prev, "Gone")
+
+	next := NewBuilder()
+	New(FieldStruct).Reg(next, "Pos").
+		Add("B", "", false, NewString()).
+		Add("A", "", false, New(FieldBool)).
+		Add("C", "", false, New(FieldInt16))
+	New(FieldStruct).Reg(next, "Tagged").Evolvable().
+		AddID(1, "AA", "", true, New(FieldBool)).
+		Reserve(2).
+		AddID(4, "D", "", false, NewString())
+	New(FieldBool).Reg(next, "New")
+
+	changes := Compare(prev, next)
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	require.Equal(t, []string{
+		"breaking: Gone: type removed",
+		"breaking: Pos.A: field moved from position 0 to 1",
+		"breaking: Pos.A: type changed from bool to string",
+		"breaking: Pos.B: field moved from position 1 to 0",
+		"breaking: Pos.B: type changed from string to bool",
+		"breaking: Pos.C: optional changed from true to false",
+		"breaking: Pos.C: type changed from int8 to int16",
+		"safe: Tagged.A: field 1 renamed to AA",
+		"safe: Tagged.AA: optional changed from false to true",
+		"safe: Tagged.B: field 2 removed and reserved",
+		"breaking: Tagged.C: field 3 removed without being reserved",
+		"safe: Tagged.D: field 4 added",
+		"safe: New: type added",
+	}, got)
+
+	require.NoError(t, CheckCompat(prev, prev))
+	require.True(t, errors.Is(CheckCompat(prev, next), ErrBreaking))
+}
+
+func TestCompareTypeID(t *testing.T) {
+	prev := NewBuilder()
+	New(FieldStruct).Reg(prev, "Msg").TypeID(1).Add("A", "", false, New(FieldBool))
+	next := NewBuilder()
+	New(FieldStruct).Reg(next, "Msg").TypeID(2).Add("A", "", false, New(FieldBool))
+	require.Equal(t, []Change{{Path: "Msg", Message: "type id changed from 1 to 2", Breaking: true}}, Compare(prev, next))
+
+	next = NewBuilder()
+	msg := New(FieldStruct).Reg(next, "Msg").Add("A", "", false, New(FieldBool))
+	require.Equal(t, []Change{{Path: "Msg", Message: fmt.Sprintf("type id changed from 1 to %d", msg.messageID()), Breaking: true}}, Compare(prev, next))
+}
@@ -0,0 +1,105 @@
+package bstruct
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrCompression = errors.New("bstruct: invalid compression flag")
+
+// Compressed payloads start with a flag byte telling whether the rest is
+// deflated.
+const (
+	flagRaw = iota
+	flagFlate
+)
+
+var (
+	compressors = sync.Pool{
+		New: func() any {
+			w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+			return w
+		},
+	}
+	decompressors = sync.Pool{
+		New: func() any {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+// AppendCompressed appends data to dst, deflated if it has at least threshold
+// bytes and deflating makes it smaller, behind the flag byte read by
+// AppendDecompressed.
+func AppendCompressed(dst, data []byte, threshold int) []byte {
+	if len(data) < threshold {
+		return append(append(dst, flagRaw), data...)
+	}
+	start := len(dst)
+	buf := bytes.NewBuffer(append(dst, flagFlate))
+	w := compressors.Get().(*flate.Writer)
+	w.Reset(buf)
+	// writes to a bytes.Buffer do not fail
+	_, _ = w.Write(data)
+	_ = w.Close()
+	compressors.Put(w)
+
+	out := buf.Bytes()
+	if len(out)-start-1 >= len(data) {
+		return append(append(out[:start], flagRaw), data...)
+	}
+	return out
+}
+
+// AppendDecompressed appends the payload of data, as written by
+// AppendCompressed, to dst. Payloads inflating beyond max bytes fail with
+// ErrFrameTooLarge.
+func AppendDecompressed(dst, data []byte, max int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrShortData
+	}
+	switch data[0] {
+	case flagRaw:
+		if len(data)-1 > max {
+			return nil, ErrFrameTooLarge
+		}
+		return append(dst, data[1:]...), nil
+	case flagFlate:
+	default:
+		return nil, ErrCompression
+	}
+
+	r := decompressors.Get().(io.ReadCloser)
+	defer decompressors.Put(r)
+	_ = r.(flate.Resetter).Reset(bytes.NewReader(data[1:]), nil)
+	buf := bytes.NewBuffer(dst)
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(max) {
+		return nil, ErrFrameTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// Compress replaces the content of w by its compressed form, see
+// AppendCompressed.
+func (w *Writer) Compress(threshold int) {
+	data := AppendCompressed(nil, w.Data(), threshold)
+	w.Reset()
+	w.Write(data)
+}
+
+// NewCompressedReader returns a Reader of the payload of data, as written by
+// Compress, decompressing it if needed.
+func NewCompressedReader(data []byte) (*Reader, error) {
+	data, err := AppendDecompressed(nil, data, DefaultMaxFrame)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(data), nil
+}
@@ -0,0 +1,46 @@
+package bstruct
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	small := []byte("abc")
+	data := AppendCompressed([]byte{9}, small, 16)
+	require.Equal(t, []byte{9, flagRaw, 'a', 'b', 'c'}, data)
+	out, err := AppendDecompressed(nil, data[1:], DefaultMaxFrame)
+	require.NoError(t, err)
+	require.Equal(t, small, out)
+
+	large := bytes.Repeat([]byte("repeated string "), 256)
+	data = AppendCompressed(nil, large, 16)
+	require.Equal(t, byte(flagFlate), data[0])
+	require.Less(t, len(data), len(large)/10)
+	out, err = AppendDecompressed(nil, data, DefaultMaxFrame)
+	require.NoError(t, err)
+	require.Equal(t, large, out)
+	_, err = AppendDecompressed(nil, data, len(large)-1)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+
+	// incompressible data stays raw
+	random := []byte{0x8f, 0x12, 0xe4, 0x3b, 0x71, 0xc9, 0x05, 0xaa}
+	require.Equal(t, byte(flagRaw), AppendCompressed(nil, random, 0)[0])
+
+	_, err = AppendDecompressed(nil, []byte{7}, DefaultMaxFrame)
+	require.ErrorIs(t, err, ErrCompression)
+	_, err = AppendDecompressed(nil, nil, DefaultMaxFrame)
+	require.ErrorIs(t, err, ErrShortData)
+
+	wt := NewWriter()
+	wt.WriteLen(len(large))
+	wt.Write(large)
+	plain := append([]byte(nil), wt.Data()...)
+	wt.Compress(16)
+	require.Less(t, len(wt.Data()), len(plain))
+	rd, err := NewCompressedReader(wt.Data())
+	require.NoError(t, err)
+	require.Equal(t, plain, rd.Data())
+}
@@ -31,6 +31,9 @@ func newPtr(l ast.Expr) *ast.UnaryExpr {
 	return &ast.UnaryExpr{X: l, Op: token.AND}
 }
 
+// unknownName is the field holding unknown fields of an evolvable struct.
+const unknownName = "__unknown"
+
 func newOpt(l string) string {
 	return fmt.Sprintf("__%s", l)
 }
@@ -94,6 +97,23 @@ func intLit[T integer](d T) *ast.BasicLit {
 	return &ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("%d", d)}
 }
 
+func wireSel(wire int) *ast.SelectorExpr {
+	switch wire {
+	case Wire8:
+		return newSel("bstruct", "Wire8")
+	case Wire16:
+		return newSel("bstruct", "Wire16")
+	case Wire32:
+		return newSel("bstruct", "Wire32")
+	case Wire64:
+		return newSel("bstruct", "Wire64")
+	case WireBytes:
+		return newSel("bstruct", "WireBytes")
+	default:
+		return newSel("bstruct", "WireEnd")
+	}
+}
+
 func newIdent(l any) ast.Expr {
 	switch v := l.(type) {
 	case string:
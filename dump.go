@@ -0,0 +1,63 @@
+package bstruct
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Dump walks data as schema f, prefixed by the schema hash in envelope mode,
+// and writes a line per decoded byte range: offsets, bytes, field path, type
+// and value. Where decoding diverges, a line marked by "!!" shows the offset,
+// the bytes left and the reason, which is returned as well.
+func Dump(w io.Writer, data []byte, f *Field, envelope bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	line := func(start, end int, path, kind string, value any) {
+		fmt.Fprintf(tw, "0x%04x-0x%04x\t%s\t%s\t%s\t%v\n", start, end, hexBytes(data[start:end]), path, kind, value)
+	}
+
+	name := f.typename
+	if name == "" {
+		name = f.typ.String()
+	}
+	r := NewReader(data)
+	if envelope && r.CheckHash(f.SchemaHash()) {
+		line(0, r.Pos(), fmt.Sprintf("hash(%s)", name), "uint64", fmt.Sprintf("%#016x", f.SchemaHash()))
+	}
+
+	var err error
+	if r.Err() == nil {
+		d := &dynDecoder{r: r, trace: line}
+		_, err = d.decode(f, name)
+	}
+	if err == nil {
+		err = r.Err()
+	}
+	if err == nil && r.Pos() < len(data) {
+		err = fmt.Errorf("bstruct: %d trailing bytes", len(data)-r.Pos())
+	}
+	if err != nil {
+		fmt.Fprintf(tw, "!! 0x%04x\t%s\t\t\t%v\n", r.Pos(), hexBytes(data[r.Pos():]), err)
+	}
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func hexBytes(b []byte) string {
+	const max = 8
+	var sb strings.Builder
+	for i, c := range b {
+		if i == max {
+			sb.WriteString(" ..")
+			break
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02x", c)
+	}
+	return sb.String()
+}
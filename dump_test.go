@@ -0,0 +1,37 @@
+package bstruct
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	f := New(FieldStruct).
+		Add("A", "", false, New(FieldBool)).
+		Add("B", "", true, NewSlice(NewString())).
+		Add("C", "", false, New(FieldStruct).Evolvable().Add("D", "", false, New(FieldUint16)))
+	wt := NewWriter()
+	require.NoError(t, EncodeValue(wt, f, map[string]any{
+		"A": true,
+		"B": []any{"xy"},
+		"C": map[string]any{"D": 258},
+	}))
+
+	buf := new(strings.Builder)
+	require.NoError(t, Dump(buf, wt.Data(), f, false))
+	require.Equal(t, `0x0000-0x0001  01     struct.A          bool    true
+0x0001-0x0002  01     has(struct.B)     bool    true
+0x0002-0x0003  02     len(struct.B)     varint  1
+0x0003-0x0004  04     len(struct.B[0])  varint  2
+0x0004-0x0006  78 79  struct.B[0]       string  "xy"
+0x0006-0x0007  0a     tag(struct.C.D)   tag     id 1, wire 2
+0x0007-0x0009  02 01  struct.C.D        uint16  258
+0x0009-0x000a  00     end(struct.C)     tag     id 0, wire 0
+`, buf.String())
+
+	buf.Reset()
+	require.ErrorIs(t, Dump(buf, wt.Data()[:8], f, false), ErrShortData)
+	require.Regexp(t, `!! 0x0007 +02 +bstruct: unexpected end of data`, buf.String())
+}
@@ -0,0 +1,334 @@
+package bstruct
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"unsafe"
+)
+
+// DecodeValue decodes a value of schema f without the generated type. Structs
+// become map[string]any without the keys of absent optional fields, slices
+// become []any and primitives their Go type, e.g. int16. Unknown fields kept
+// by an evolvable struct are stored as []byte under "__unknown".
+//
+// Strings are copied, the result does not alias the data of r.
+func DecodeValue(r *Reader, f *Field) (any, error) {
+	d := &dynDecoder{r: r}
+	v, err := d.decode(f, "")
+	if err != nil {
+		return nil, err
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return v, nil
+}
+
+// EncodeValue encodes v, in the form returned by DecodeValue, as schema f.
+// Numbers may be of any Go numeric type or json.Number, slices of any type,
+// missing struct fields are encoded as zero values.
+func EncodeValue(w *Writer, f *Field, v any) error {
+	return encodeDynamic(w, f, v)
+}
+
+// tracer is told about every decoded byte range, see Dump.
+type tracer func(start, end int, path, kind string, value any)
+
+type dynDecoder struct {
+	r     *Reader
+	trace tracer
+}
+
+func (d *dynDecoder) emit(start int, path, kind string, value any) {
+	if d.trace != nil && d.r.Err() == nil {
+		d.trace(start, d.r.Pos(), path, kind, value)
+	}
+}
+
+// child builds the path of a nested value, only when tracing.
+func (d *dynDecoder) child(format string, args ...any) string {
+	if d.trace == nil {
+		return ""
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+func (d *dynDecoder) readLen(path string, size int) int {
+	start := d.r.Pos()
+	length := d.r.ReadCount(size)
+	d.emit(start, d.child("len(%s)", path), "varint", length)
+	return length
+}
+
+func (d *dynDecoder) decode(f *Field, path string) (any, error) {
+	r := d.r
+	start := r.Pos()
+	switch {
+	case f.typ.IsPrimitive():
+		p := reflect.New(f.typ.reflectType())
+		r.Copy(p.UnsafePointer(), int(f.typ.Size()))
+		d.emit(start, path, f.typ.String(), p.Elem().Interface())
+		return p.Elem().Interface(), nil
+	case f.typ.IsType(FieldString):
+		length := d.readLen(path, 1)
+		if length == 0 {
+			return "", nil
+		}
+		start = r.Pos()
+		r.Skip(length)
+		if r.Err() != nil {
+			return "", nil
+		}
+		s := string(r.Data()[start:r.Pos()])
+		d.emit(start, path, "string", strconv.Quote(s))
+		return s, nil
+	case f.typ.IsType(FieldSlice):
+		size := f.sliceType.minSize()
+		length := d.readLen(path, size)
+		// only lengths bounded by the data are trusted upfront
+		s := []any{}
+		if size > 0 {
+			s = make([]any, 0, length)
+		}
+		for i := 0; i < length && r.Err() == nil; i++ {
+			el, err := d.decode(f.sliceType, d.child("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, el)
+		}
+		return s, nil
+	case f.typ.IsType(FieldStruct) && f.evolvable:
+		m := make(map[string]any)
+		var unknown []byte
+		for {
+			start := r.Pos()
+			id, wire := r.ReadTag()
+			if wire == WireEnd {
+				d.emit(start, d.child("end(%s)", path), "tag", "id 0, wire 0")
+				break
+			}
+			sf, ok := f.fieldByID(uint(id))
+			if !ok {
+				r.SkipWire(wire)
+				d.emit(start, d.child("%s.#%d", path, id), "unknown", fmt.Sprintf("wire %d", wire))
+				if f.unknown {
+					unknown = append(unknown, r.Data()[start:r.Pos()]...)
+				}
+				continue
+			}
+			fpath := d.child("%s.%s", path, sf.strucName)
+			d.emit(start, d.child("tag(%s)", fpath), "tag", fmt.Sprintf("id %d, wire %d", id, wire))
+			if wire != sf.wire() {
+				start = r.Pos()
+				r.SkipWire(wire)
+				d.emit(start, fpath, "skipped", fmt.Sprintf("wire %d, want %d", wire, sf.wire()))
+				continue
+			}
+			end := -1
+			if wire == WireBytes {
+				end = d.readLen(d.child("bytes(%s)", fpath), 1)
+				end += r.Pos()
+			}
+			el, err := d.decode(sf.Field, fpath)
+			if err != nil {
+				return nil, err
+			}
+			if end >= 0 {
+				r.SkipTo(end)
+			}
+			m[sf.strucName] = el
+		}
+		if len(unknown) > 0 {
+			m[unknownName] = unknown
+		}
+		return m, nil
+	case f.typ.IsType(FieldStruct):
+		m := make(map[string]any)
+		for _, sf := range f.strucFields {
+			fpath := d.child("%s.%s", path, sf.strucName)
+			if sf.optional {
+				var has bool
+				start := r.Pos()
+				r.Copy(unsafe.Pointer(&has), 1)
+				d.emit(start, d.child("has(%s)", fpath), "bool", has)
+				if !has {
+					continue
+				}
+			}
+			el, err := d.decode(sf.Field, fpath)
+			if err != nil {
+				return nil, err
+			}
+			m[sf.strucName] = el
+		}
+		return m, nil
+	case f.typ.IsType(FieldCustom):
+		return nil, fmt.Errorf("%s: %w", path, ErrCustom)
+	default:
+		return nil, fmt.Errorf("bstruct: can not decode %s", f.typ)
+	}
+}
+
+func encodeDynamic(w *Writer, f *Field, v any) error {
+	switch {
+	case f.typ.IsPrimitive():
+		p := reflect.New(f.typ.reflectType())
+		if err := setNumber(p.Elem(), v); err != nil {
+			return err
+		}
+		w.Copy(p.UnsafePointer(), int(f.typ.Size()))
+	case f.typ.IsType(FieldString):
+		var s string
+		switch v := v.(type) {
+		case nil:
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			return fmt.Errorf("bstruct: can not encode %T as string", v)
+		}
+		w.WriteLen(len(s))
+		w.Write([]byte(s))
+	case f.typ.IsType(FieldSlice):
+		rv := reflect.ValueOf(v)
+		if v == nil {
+			w.WriteLen(0)
+			break
+		}
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("bstruct: can not encode %T as slice", v)
+		}
+		w.WriteLen(rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := encodeDynamic(w, f.sliceType, rv.Index(i).Interface()); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case f.typ.IsType(FieldStruct):
+		m, ok := v.(map[string]any)
+		if !ok && v != nil {
+			return fmt.Errorf("bstruct: can not encode %T as struct", v)
+		}
+		for _, sf := range f.strucFields {
+			el, present := m[sf.strucName]
+			present = present && el != nil
+			if !f.evolvable && sf.optional {
+				w.Copy(unsafe.Pointer(&present), 1)
+			}
+			if sf.optional && !present {
+				continue
+			}
+			start := 0
+			if f.evolvable {
+				w.WriteTag(int(sf.id), sf.wire())
+				start = w.Pos()
+			}
+			if err := encodeDynamic(w, sf.Field, el); err != nil {
+				return fmt.Errorf("%s: %w", sf.strucName, err)
+			}
+			if f.evolvable && sf.wire() == WireBytes {
+				w.PrefixLen(start)
+			}
+		}
+		if f.evolvable && f.unknown {
+			switch unknown := m[unknownName].(type) {
+			case []byte:
+				w.Write(unknown)
+			case string:
+				// base64, as encoding/json writes a []byte
+				data, err := base64.StdEncoding.DecodeString(unknown)
+				if err != nil {
+					return fmt.Errorf("%s: %w", unknownName, err)
+				}
+				w.Write(data)
+			}
+		}
+		if f.evolvable {
+			w.WriteTag(0, WireEnd)
+		}
+	case f.typ.IsType(FieldCustom):
+		return ErrCustom
+	default:
+		return fmt.Errorf("bstruct: can not encode %s", f.typ)
+	}
+	return nil
+}
+
+// setNumber stores the bool or number v into the primitive dst, failing if it
+// does not fit.
+func setNumber(dst reflect.Value, v any) error {
+	if v == nil {
+		return nil
+	}
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			v = i
+		} else if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+			v = u
+		} else if f, err := n.Float64(); err == nil {
+			v = f
+		} else {
+			return err
+		}
+	}
+
+	src := reflect.ValueOf(v)
+	fail := fmt.Errorf("bstruct: can not encode %v as %s", v, dst.Type())
+	switch dst.Kind() {
+	case reflect.Bool:
+		if src.Kind() != reflect.Bool {
+			return fail
+		}
+		dst.SetBool(src.Bool())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch {
+		case src.CanInt():
+			i = src.Int()
+		case src.CanUint() && src.Uint() <= math.MaxInt64:
+			i = int64(src.Uint())
+		case src.CanFloat() && src.Float() == math.Trunc(src.Float()) && math.Abs(src.Float()) <= 1<<63:
+			i = int64(src.Float())
+		default:
+			return fail
+		}
+		if dst.OverflowInt(i) {
+			return fail
+		}
+		dst.SetInt(i)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch {
+		case src.CanUint():
+			u = src.Uint()
+		case src.CanInt() && src.Int() >= 0:
+			u = uint64(src.Int())
+		case src.CanFloat() && src.Float() == math.Trunc(src.Float()) && src.Float() >= 0 && src.Float() < 1<<64:
+			u = uint64(src.Float())
+		default:
+			return fail
+		}
+		if dst.OverflowUint(u) {
+			return fail
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case src.CanFloat():
+			dst.SetFloat(src.Float())
+		case src.CanInt():
+			dst.SetFloat(float64(src.Int()))
+		case src.CanUint():
+			dst.SetFloat(float64(src.Uint()))
+		default:
+			return fail
+		}
+	}
+	return nil
+}
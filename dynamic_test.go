@@ -0,0 +1,52 @@
+package bstruct
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamic(t *testing.T) {
+	for _, v := range []any{
+		&codecStruct{
+			A: true,
+			B: []uint32{1, 2},
+			C: codecInner{A: -3, B: "x"},
+			D: []codecInner{{A: 1}},
+			f: []string{"a"},
+		},
+		&evolveV2{A: 2, __D: true, D: codecInner{A: 3, B: "new"}, E: -1, C: []bool{false}},
+	} {
+		data, err := Marshal(v)
+		require.NoError(t, err)
+		f, err := cachedField(reflect.TypeOf(v).Elem())
+		require.NoError(t, err)
+
+		dyn, err := DecodeValue(NewReader(data), f)
+		require.NoError(t, err)
+		wt := NewWriter()
+		require.NoError(t, EncodeValue(wt, f, dyn))
+		require.Equal(t, data, wt.Data())
+
+		js, err := json.Marshal(dyn)
+		require.NoError(t, err)
+		var loose any
+		require.NoError(t, json.Unmarshal(js, &loose))
+		wt = NewWriter()
+		require.NoError(t, EncodeValue(wt, f, loose))
+		require.Equal(t, data, wt.Data())
+	}
+
+	f := New(FieldStruct).
+		Add("A", "", false, New(FieldInt8)).
+		Add("B", "", true, NewString())
+	dyn, err := DecodeValue(NewReader([]byte{0xff, 0}), f)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"A": int8(-1)}, dyn)
+	require.Error(t, EncodeValue(NewWriter(), f, map[string]any{"A": 300}))
+	require.Error(t, EncodeValue(NewWriter(), f, map[string]any{"A": 1.5}))
+	_, err = DecodeValue(NewReader([]byte{0xff, 1, 4}), f)
+	require.ErrorIs(t, err, ErrInvalidLen)
+}
@@ -1,8 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/xhebox/bstruct"
@@ -18,14 +32,14 @@ func makeData(b *testing.B) []byte {
 func TestAff(t *testing.T) {
 	f := &Struct1{
 		B: nil,
-		F: true,
+		A: true,
 		D: "gg",
 		G: []string{
 			"1",
 			"3",
 			"154",
 		},
-		E: Slice1{
+		E: []Slice1{
 			{E: "1"},
 		},
 	}
@@ -107,3 +121,835 @@ func BenchmarkUnmarshal(b *testing.B) {
 		require.NoError(b, err)
 	}
 }
+
+func TestMarshal(t *testing.T) {
+	f := &Struct1{
+		A: true,
+		B: []bool{true, false},
+		D: "gg",
+		G: []string{"1", "3", "154"},
+		E: []Slice1{
+			{E: "1"},
+		},
+		__fieldGerrrccontrol: true,
+		fieldGerrrccontrol:   true,
+	}
+
+	wt := bstruct.NewWriter()
+	f.Encode(wt)
+	data, err := bstruct.Marshal(f)
+	require.NoError(t, err)
+	require.Equal(t, wt.Data(), data)
+
+	g := &Struct1{}
+	require.NoError(t, bstruct.Unmarshal(data, g))
+	require.Equal(t, f, g)
+}
+
+func TestEvolvable(t *testing.T) {
+	f := &Struct3{
+		A:   7,
+		__B: true,
+		B:   "b",
+		C:   []string{"x", "yz"},
+	}
+
+	wt := bstruct.NewWriter()
+	f.Encode(wt)
+	data, err := bstruct.Marshal(f)
+	require.NoError(t, err)
+	require.Equal(t, wt.Data(), data)
+
+	g := &Struct3{}
+	rd := bstruct.NewReader(wt.Data())
+	g.Decode(rd)
+	require.NoError(t, rd.Err())
+	require.Equal(t, f, g)
+}
+
+type struct3Next struct {
+	A uint32   `bstruct:"id=1"`
+	C []string `bstruct:"id=4"`
+	D string   `bstruct:"id=5"`
+	E []uint16 `bstruct:"id=6"`
+}
+
+type struct3Grown struct {
+	A uint32 `bstruct:"id=1"`
+	C struct {
+		C []string
+		D uint64
+	} `bstruct:"id=4"`
+}
+
+func TestUnknown(t *testing.T) {
+	next := &struct3Next{A: 1, C: []string{"c"}, D: "d", E: []uint16{1, 2}}
+	data, err := bstruct.Marshal(next)
+	require.NoError(t, err)
+
+	f := &Struct3{}
+	rd := bstruct.NewReader(data)
+	f.Decode(rd)
+	require.NoError(t, rd.Err())
+	require.Equal(t, []string{"c"}, f.C)
+	f.A = 2
+
+	wt := bstruct.NewWriter()
+	f.Encode(wt)
+	reencoded, err := bstruct.Marshal(f)
+	require.NoError(t, err)
+	require.Equal(t, wt.Data(), reencoded)
+
+	g := &struct3Next{}
+	require.NoError(t, bstruct.Unmarshal(wt.Data(), g))
+	next.A = 2
+	require.Equal(t, next, g)
+
+	grown := &struct3Grown{A: 3}
+	grown.C.C = []string{"x"}
+	grown.C.D = 9
+	data, err = bstruct.Marshal(grown)
+	require.NoError(t, err)
+	f = &Struct3{__B: true, B: "stale"}
+	rd = bstruct.NewReader(data)
+	f.Decode(rd)
+	require.NoError(t, rd.Err())
+	require.Equal(t, &Struct3{A: 3, C: []string{"x"}}, f)
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*Struct1)(nil)
+	_ encoding.BinaryUnmarshaler = (*Struct1)(nil)
+	_ io.WriterTo                = (*Struct1)(nil)
+	_ io.ReaderFrom              = (*Struct1)(nil)
+)
+
+func TestBinary(t *testing.T) {
+	f := &Struct1{D: "gg", G: []string{"1", "3"}}
+	data, err := f.MarshalBinary()
+	require.NoError(t, err)
+	prefixed, err := f.AppendBinary([]byte{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, append([]byte{1, 2}, data...), prefixed)
+
+	g := &Struct1{}
+	require.NoError(t, g.UnmarshalBinary(data))
+	require.Equal(t, f, g)
+	data[len(data)-1] = 'x'
+	require.Equal(t, "3", g.G[1])
+
+	require.Error(t, g.UnmarshalBinary(data[:len(data)-1]))
+}
+
+func TestGeneric(t *testing.T) {
+	f := &Struct1{D: "gg", G: []string{"1", "3"}}
+	data := bstruct.Encode(f)
+	wt := bstruct.NewWriter()
+	f.Encode(wt)
+	require.Equal(t, wt.Data(), data)
+	require.Equal(t, data, bstruct.Encode(f))
+
+	g, err := bstruct.Decode[Struct1](data)
+	require.NoError(t, err)
+	require.Equal(t, *f, g)
+
+	_, err = bstruct.Decode[Struct1](data[:len(data)-1])
+	require.ErrorIs(t, err, bstruct.ErrShortData)
+}
+
+func BenchmarkGenericEncode(b *testing.B) {
+	f := &Struct1{
+		G: []string{
+			"1",
+			"3",
+			"154",
+		},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bstruct.Encode(f)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	a := &Struct1{D: "a", G: []string{"1"}}
+	b := &Struct1{D: "b", A: true}
+	n, err := a.WriteTo(&buf)
+	require.NoError(t, err)
+	m, err := b.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n+m)
+
+	g := &Struct1{}
+	n, err = g.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, a, g)
+	g = &Struct1{}
+	m, err = g.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, b, g)
+	require.Equal(t, n+m, int64(len(bstruct.Encode(a))+len(bstruct.Encode(b))+2))
+
+	_, err = g.ReadFrom(&buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestFrames(t *testing.T) {
+	for _, fixed := range []bool{false, true} {
+		var buf bytes.Buffer
+		fw := bstruct.NewFrameWriter(&buf).Fixed(fixed)
+		a := &Struct1{D: "a", G: []string{"1"}}
+		b := &Struct1{D: "b", A: true}
+		require.NoError(t, fw.Encode(a))
+		require.NoError(t, fw.Encode(b))
+		require.ErrorIs(t, fw.MaxSize(4).Encode(a), bstruct.ErrFrameTooLarge)
+
+		fr := bstruct.NewFrameReader(bytes.NewReader(buf.Bytes())).Fixed(fixed)
+		g := &Struct1{}
+		require.NoError(t, fr.Decode(g))
+		require.Equal(t, a, g)
+		g = &Struct1{}
+		require.NoError(t, fr.Decode(g))
+		require.Equal(t, b, g)
+		require.ErrorIs(t, fr.Decode(g), io.EOF)
+
+		fr = bstruct.NewFrameReader(bytes.NewReader(buf.Bytes())).Fixed(fixed).MaxSize(4)
+		require.ErrorIs(t, fr.Decode(g), bstruct.ErrFrameTooLarge)
+		fr = bstruct.NewFrameReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1])).Fixed(fixed)
+		require.NoError(t, fr.Decode(g))
+		require.ErrorIs(t, fr.Decode(g), io.ErrUnexpectedEOF)
+	}
+
+	// frames of WriteTo and FrameWriter are alike
+	var buf bytes.Buffer
+	a := &Struct1{D: "a", G: []string{"1"}}
+	_, err := a.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NoError(t, bstruct.NewFrameWriter(&buf).Encode(a))
+	fr := bstruct.NewFrameReader(bytes.NewReader(buf.Bytes()))
+	g := &Struct1{}
+	require.NoError(t, fr.Decode(g))
+	require.Equal(t, a, g)
+	g = &Struct1{}
+	require.NoError(t, fr.Decode(g))
+	require.Equal(t, a, g)
+	for i := 0; i < 2; i++ {
+		g = &Struct1{}
+		_, err = g.ReadFrom(&buf)
+		require.NoError(t, err)
+		require.Equal(t, a, g)
+	}
+}
+
+func BenchmarkFrames(b *testing.B) {
+	f := &Struct1{
+		G: []string{
+			"1",
+			"3",
+			"154",
+		},
+	}
+	fw := bstruct.NewFrameWriter(io.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = fw.Encode(f)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	require.Equal(t, uint64(3), (&Struct3{}).TypeID())
+	require.NotEqual(t, (&Struct1{}).SchemaHash(), (&Struct1{}).TypeID())
+
+	wt := bstruct.NewWriter()
+	a := &Struct1{D: "a", G: []string{"1"}}
+	b := &Struct3{A: 7, C: []string{"c"}}
+	bstruct.EncodeAny(wt, a)
+	bstruct.EncodeAny(wt, b)
+
+	rd := bstruct.NewReader(wt.Data())
+	v, err := bstruct.DecodeAny(rd)
+	require.NoError(t, err)
+	require.Equal(t, a, v)
+	v, err = bstruct.DecodeAny(rd)
+	require.NoError(t, err)
+	require.Equal(t, b, v)
+
+	_, err = bstruct.DecodeAny(bstruct.NewReader([]byte{42}))
+	require.ErrorIs(t, err, bstruct.ErrUnknownType)
+	require.Panics(t, func() {
+		bstruct.Register(func() bstruct.Message { return &otherStruct3{} })
+	})
+}
+
+type otherStruct3 struct{ Struct3 }
+
+type echoServer struct {
+	deadline chan time.Time
+	ended    chan error
+}
+
+func (s *echoServer) Echo(ctx context.Context, req *Struct1) (*Struct1, error) {
+	switch req.D {
+	case "fail":
+		return nil, errors.New("failed")
+	case "panic":
+		return nil, nil
+	case "wait":
+		deadline, _ := ctx.Deadline()
+		s.deadline <- deadline
+		<-ctx.Done()
+		s.ended <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	return req, nil
+}
+
+func (s *echoServer) Count(ctx context.Context, req *Struct3) (*Struct3, error) {
+	return &Struct3{A: uint32(len(req.C))}, nil
+}
+
+func TestRPC(t *testing.T) {
+	a, b := net.Pipe()
+	srv := bstruct.NewServer()
+	impl := &echoServer{deadline: make(chan time.Time, 1), ended: make(chan error, 1)}
+	RegisterEchoServer(srv, impl)
+	done := make(chan error)
+	go func() { done <- srv.ServeConn(b) }()
+
+	cl := bstruct.NewClient(a)
+	c := NewEchoClient(cl)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	counts := make([]uint32, 16)
+	errs := make([]error, 16)
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := c.Count(ctx, &Struct3{C: make([]string, i)})
+			if errs[i] = err; err == nil {
+				counts[i] = resp.A
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range counts {
+		require.NoError(t, errs[i])
+		require.Equal(t, uint32(i), counts[i])
+	}
+
+	req := &Struct1{D: "gg", G: []string{"1", "3"}}
+	resp, err := c.Echo(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, req, resp)
+
+	var serr *bstruct.ServerError
+	_, err = c.Echo(ctx, &Struct1{D: "fail"})
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, "failed", serr.Message)
+	_, err = c.Echo(ctx, &Struct1{D: "panic"})
+	require.ErrorAs(t, err, &serr)
+	require.Contains(t, serr.Message, "panic")
+	err = cl.Call(ctx, "Echo.Nope", req, req)
+	require.ErrorAs(t, err, &serr)
+
+	deadline := time.Now().Add(50 * time.Millisecond)
+	tctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	_, err = c.Echo(tctx, &Struct1{D: "wait"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.True(t, deadline.Equal(<-impl.deadline))
+	// the cancel sent by the client may beat the deadline on the server
+	require.Error(t, <-impl.ended)
+
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-impl.deadline
+		cancel()
+	}()
+	_, err = c.Echo(cctx, &Struct1{D: "wait"})
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, <-impl.ended, context.Canceled)
+
+	require.NoError(t, cl.Close())
+	require.NoError(t, <-done)
+	_, err = c.Echo(ctx, req)
+	require.Error(t, err)
+
+	// frames beyond the limits fail the connection
+	large := &Struct1{D: strings.Repeat("x", 100)}
+	a, b = net.Pipe()
+	go func() { done <- srv.MaxSize(64).ServeConn(b) }()
+	c = NewEchoClient(bstruct.NewClient(a))
+	_, err = c.Echo(ctx, large)
+	require.Error(t, err)
+	require.ErrorIs(t, <-done, bstruct.ErrFrameTooLarge)
+
+	a, b = net.Pipe()
+	go func() { done <- srv.MaxSize(bstruct.DefaultMaxFrame).ServeConn(b) }()
+	cl = bstruct.NewClient(a).MaxSize(64)
+	c = NewEchoClient(cl)
+	_, err = c.Echo(ctx, large)
+	require.ErrorIs(t, err, bstruct.ErrClosed)
+	require.NoError(t, cl.Close())
+	<-done
+}
+
+func TestRecords(t *testing.T) {
+	hash := (&Struct3{}).SchemaHash()
+	for _, index := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "log")
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		rw, err := bstruct.NewRecordWriter(f, hash)
+		require.NoError(t, err)
+		rw.Index(index)
+		var recs []*Struct3
+		for i := 0; i < 10; i++ {
+			rec := &Struct3{A: uint32(i), C: []string{"x"}}
+			require.NoError(t, rw.Append(rec))
+			recs = append(recs, rec)
+		}
+		require.NoError(t, rw.Close())
+		require.NoError(t, f.Close())
+
+		f, err = os.Open(path)
+		require.NoError(t, err)
+		rr, err := bstruct.NewRecordReader(f, hash)
+		require.NoError(t, err)
+		for _, rec := range recs {
+			g := &Struct3{}
+			require.NoError(t, rr.Next(g))
+			require.Equal(t, rec, g)
+		}
+		require.ErrorIs(t, rr.Next(&Struct3{}), io.EOF)
+
+		info, err := f.Stat()
+		require.NoError(t, err)
+		rf, err := bstruct.OpenRecords(f, info.Size(), hash)
+		require.NoError(t, err)
+		require.Equal(t, len(recs), rf.Len())
+		g := &Struct3{}
+		require.NoError(t, rf.Read(7, g))
+		require.Equal(t, recs[7], g)
+		require.Error(t, rf.Read(10, g))
+		_, err = bstruct.OpenRecords(f, info.Size(), hash+1)
+		require.ErrorIs(t, err, bstruct.ErrSchemaMismatch)
+		require.NoError(t, f.Close())
+	}
+}
+
+func TestRecordsRecover(t *testing.T) {
+	hash := (&Struct3{}).SchemaHash()
+	path := filepath.Join(t.TempDir(), "log")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	rw, err := bstruct.NewRecordWriter(f, hash)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rw.Append(&Struct3{A: uint32(i)}))
+	}
+	info, err := f.Stat()
+	require.NoError(t, err)
+	intact := info.Size()
+	require.NoError(t, rw.Append(&Struct3{A: 3, C: []string{"torn"}}))
+	info, err = f.Stat()
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(info.Size()-2))
+
+	rr, err := bstruct.NewRecordReader(bytes.NewReader(mustRead(t, path)), hash)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rr.Next(&Struct3{}))
+	}
+	require.ErrorIs(t, rr.Next(&Struct3{}), io.ErrUnexpectedEOF)
+
+	n, err := bstruct.Recover(f, hash)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	info, err = f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, intact, info.Size())
+
+	data := mustRead(t, path)
+	data[len(data)-5] ^= 1
+	rr, err = bstruct.NewRecordReader(bytes.NewReader(data), hash)
+	require.NoError(t, err)
+	require.NoError(t, rr.Next(&Struct3{}))
+	require.NoError(t, rr.Next(&Struct3{}))
+	require.ErrorIs(t, rr.Next(&Struct3{}), bstruct.ErrChecksum)
+
+	// a bad checksum in the middle is corruption, not a torn tail
+	mid := mustRead(t, path)
+	mid[13+(len(mid)-13)/3+1] ^= 1
+	require.NoError(t, os.WriteFile(path, mid, 0o644))
+	_, err = bstruct.OpenRecords(bytes.NewReader(mid), int64(len(mid)), hash)
+	require.ErrorIs(t, err, bstruct.ErrChecksum)
+	_, err = bstruct.Recover(f, hash)
+	require.ErrorIs(t, err, bstruct.ErrChecksum)
+	require.Equal(t, mid, mustRead(t, path))
+
+	// a bad checksum on the last record is a torn tail
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	n, err = bstruct.Recover(f, hash)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// recovered files are appended to, closed ones as well
+	for i, index := range []bool{true, false} {
+		rw, err = bstruct.OpenRecordWriter(f, hash)
+		require.NoError(t, err)
+		require.NoError(t, rw.Append(&Struct3{A: uint32(7 + i)}))
+		require.NoError(t, rw.Index(index).Close())
+	}
+	rr, err = bstruct.NewRecordReader(bytes.NewReader(mustRead(t, path)), hash)
+	require.NoError(t, err)
+	for _, a := range []uint32{0, 1, 7, 8} {
+		g := &Struct3{}
+		require.NoError(t, rr.Next(g))
+		require.Equal(t, a, g.A)
+	}
+	require.ErrorIs(t, rr.Next(&Struct3{}), io.EOF)
+	require.NoError(t, f.Close())
+
+	// errors of the underlying reader are not torn records
+	boom := errors.New("boom")
+	data = mustRead(t, path)
+	rr, err = bstruct.NewRecordReader(io.MultiReader(bytes.NewReader(data[:14]), iotest.ErrReader(boom)), hash)
+	require.NoError(t, err)
+	require.ErrorIs(t, rr.Next(&Struct3{}), boom)
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+func TestMapped(t *testing.T) {
+	hash := (&Struct3{}).SchemaHash()
+	path := filepath.Join(t.TempDir(), "log")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	rw, err := bstruct.NewRecordWriter(f, hash)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rw.Append(&Struct3{A: uint32(i), C: []string{"mapped"}}))
+	}
+	require.NoError(t, rw.Index(true).Close())
+	require.NoError(t, f.Close())
+
+	m, err := bstruct.OpenMapped(path)
+	require.NoError(t, err)
+	require.Equal(t, mustRead(t, path), m.Bytes())
+	recs, err := m.Records(hash)
+	require.NoError(t, err)
+	require.Equal(t, 5, recs.Len())
+	g := &Struct3{}
+	require.NoError(t, recs.Read(3, g))
+	require.Equal(t, &Struct3{A: 3, C: []string{"mapped"}}, g)
+	require.NoError(t, m.Close())
+	require.ErrorIs(t, recs.Read(3, g), bstruct.ErrMappingClosed)
+	require.NoError(t, m.Close())
+
+	// indexes with a valid checksum may still be damaged
+	data := mustRead(t, path)
+	end := binary.LittleEndian.Uint64(data[len(data)-12:])
+	offsets := data[end+2 : end+2+5*8]
+	good := binary.LittleEndian.Uint64(offsets[4*8:])
+	for _, count := range []uint64{5, 1<<61 + 5} {
+		for _, last := range []uint64{good, 0, 1 << 40} {
+			body := binary.AppendUvarint(nil, count)
+			body = append(body, offsets[:4*8]...)
+			body = binary.LittleEndian.AppendUint64(body, last)
+			bad := append(append([]byte{}, data[:end+1]...), body...)
+			bad = binary.LittleEndian.AppendUint32(bad, crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)))
+			bad = binary.LittleEndian.AppendUint64(bad, end)
+			bad = append(bad, "bidx"...)
+			require.NoError(t, os.WriteFile(path, bad, 0o644))
+			m, err = bstruct.OpenMapped(path)
+			require.NoError(t, err)
+			recs, err := m.Records(hash)
+			if count == 5 && last == good {
+				require.NoError(t, err)
+				require.NoError(t, recs.Read(4, g))
+			} else {
+				require.ErrorIs(t, err, bstruct.ErrNotRecords)
+			}
+			require.NoError(t, m.Close())
+		}
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, os.WriteFile(empty, nil, 0o644))
+	m, err = bstruct.OpenMapped(empty)
+	require.NoError(t, err)
+	require.Empty(t, m.Bytes())
+	require.NoError(t, m.Close())
+}
+
+func TestFramesCompressed(t *testing.T) {
+	small := &Struct1{D: "a"}
+	large := &Struct1{G: make([]string, 100)}
+	for i := range large.G {
+		large.G[i] = "repeated string"
+	}
+	for _, fixed := range []bool{false, true} {
+		var buf bytes.Buffer
+		fw := bstruct.NewFrameWriter(&buf).Fixed(fixed).Compress(64)
+		require.NoError(t, fw.Encode(small))
+		require.NoError(t, fw.Encode(large))
+		require.Less(t, buf.Len(), len(bstruct.Encode(large))/4)
+		data := buf.Bytes()
+
+		fr := bstruct.NewFrameReader(bytes.NewReader(data)).Fixed(fixed)
+		g := &Struct1{}
+		require.NoError(t, fr.Decode(g))
+		require.Equal(t, small, g)
+		g = &Struct1{}
+		require.NoError(t, fr.Decode(g))
+		require.Equal(t, large, g)
+		require.ErrorIs(t, fr.Decode(g), io.EOF)
+
+		if !fixed {
+			_, err := g.ReadFrom(bytes.NewReader(data))
+			require.ErrorIs(t, err, bstruct.ErrInvalidLen)
+		}
+	}
+
+	fr := bstruct.NewFrameReader(bytes.NewReader([]byte{0x80, 0, 2, 7, 0}))
+	require.ErrorIs(t, fr.Decode(&Struct1{}), bstruct.ErrCompression)
+}
+
+func BenchmarkFramesCompressed(b *testing.B) {
+	f := &Struct1{G: make([]string, 100)}
+	for i := range f.G {
+		f.G[i] = "repeated string"
+	}
+	fw := bstruct.NewFrameWriter(io.Discard).Compress(64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = fw.Encode(f)
+	}
+}
+
+func TestView(t *testing.T) {
+	f := &Struct1{
+		A: true,
+		B: []bool{true, false},
+		C: Struct2{A: true},
+		D: "gg",
+		G: []string{"1", "3", "154"},
+		E: []Slice1{
+			{E: "1"},
+		},
+	}
+	data := bstruct.Encode(f)
+	v := NewStruct1View(data)
+	require.Equal(t, "gg", v.D())
+	require.Equal(t, f.E, v.E())
+	require.Equal(t, f.G, v.G())
+	require.Equal(t, f.B, v.B())
+	require.True(t, v.A())
+	require.True(t, v.C().A())
+	require.False(t, v.HasFieldGerrrccontrol())
+	require.NoError(t, v.Err())
+
+	f.__fieldGerrrccontrol = true
+	f.fieldGerrrccontrol = true
+	v = NewStruct1View(bstruct.Encode(f))
+	require.True(t, v.HasFieldGerrrccontrol())
+	require.True(t, v.FieldGerrrccontrol())
+
+	v = NewStruct1View(data[:len(data)-3])
+	require.Empty(t, v.D())
+	require.ErrorIs(t, v.Err(), bstruct.ErrInvalidLen)
+
+	g := &Struct3{A: 7, C: []string{"c"}}
+	w := NewStruct3View(bstruct.Encode(g))
+	require.Equal(t, uint32(7), w.A())
+	require.False(t, w.HasB())
+	require.Empty(t, w.B())
+	require.Equal(t, g.C, w.C())
+	require.NoError(t, w.Err())
+
+	// fields of a newer schema are skipped
+	data, err := bstruct.Marshal(&struct3Next{A: 1, C: []string{"x"}, D: "d", E: []uint16{1}})
+	require.NoError(t, err)
+	w = NewStruct3View(data)
+	require.Equal(t, uint32(1), w.A())
+	require.Equal(t, []string{"x"}, w.C())
+	require.NoError(t, w.Err())
+}
+
+func BenchmarkView(b *testing.B) {
+	f := &Struct1{
+		G: []string{
+			"1",
+			"3",
+			"154",
+		},
+		D: "gg",
+	}
+	data := bstruct.Encode(f)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewStruct1View(data).D()
+	}
+}
+
+func TestSkip(t *testing.T) {
+	f := &Struct1{
+		B: []bool{true},
+		D: "gg",
+		G: []string{"1", "3"},
+		E: []Slice1{{E: "1"}},
+	}
+	g := &Struct3{A: 1, B: "b", C: []string{"c"}}
+	g.__B = true
+	wt := bstruct.NewWriter()
+	f.Encode(wt)
+	g.Encode(wt)
+	f.Encode(wt)
+	data := wt.Data()
+
+	rd := bstruct.NewReader(data)
+	require.NoError(t, SkipStruct1(rd))
+	require.NoError(t, SkipStruct3(rd))
+	require.Equal(t, len(bstruct.Encode(f)), rd.Remaining())
+	require.NoError(t, SkipStruct1(rd))
+	require.Zero(t, rd.Remaining())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		rd := bstruct.NewReader(data)
+		_ = SkipStruct1(rd)
+		_ = SkipStruct3(rd)
+	})
+	require.LessOrEqual(t, allocs, 1.0)
+
+	rd = bstruct.NewReader(data[:len(data)-1])
+	require.NoError(t, SkipStruct1(rd))
+	require.NoError(t, SkipStruct3(rd))
+	require.Error(t, SkipStruct1(rd))
+}
+
+func TestDecodeFields(t *testing.T) {
+	f := &Struct1{
+		A:                    true,
+		B:                    []bool{true, false},
+		C:                    Struct2{A: true},
+		D:                    "gg",
+		G:                    []string{"1", "3", "154"},
+		E:                    []Slice1{{E: "1"}},
+		__fieldGerrrccontrol: true,
+		fieldGerrrccontrol:   true,
+	}
+	data := bstruct.Encode(f)
+
+	g := &Struct1{}
+	rd := bstruct.NewReader(data)
+	g.DecodeFields(rd, Struct1MaskD|Struct1MaskFieldGerrrccontrol)
+	require.NoError(t, rd.Err())
+	require.Zero(t, rd.Remaining())
+	require.Equal(t, &Struct1{D: "gg", __fieldGerrrccontrol: true, fieldGerrrccontrol: true}, g)
+
+	g = &Struct1{}
+	rd = bstruct.NewReader(data)
+	g.DecodeFields(rd, Struct1MaskAll)
+	require.NoError(t, rd.Err())
+	require.Equal(t, f, g)
+
+	// an absent optional field clears the flag, as Decode does
+	g.__fieldGerrrccontrol = true
+	g.fieldGerrrccontrol = false
+	rd = bstruct.NewReader(bstruct.Encode(&Struct1{}))
+	g.DecodeFields(rd, Struct1MaskFieldGerrrccontrol)
+	require.NoError(t, rd.Err())
+	require.False(t, g.__fieldGerrrccontrol)
+
+	// bits of evolvable structs follow the ids
+	require.Equal(t, Struct3Mask(1<<2), Struct3MaskB)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		g := Struct1{}
+		g.DecodeFields(bstruct.NewReader(data), Struct1MaskA|Struct1MaskD)
+	})
+	require.LessOrEqual(t, allocs, 1.0)
+
+	h := &Struct3{A: 7, B: "b", C: []string{"c"}}
+	h.__B = true
+	s := &Struct3{}
+	rd = bstruct.NewReader(bstruct.Encode(h))
+	s.DecodeFields(rd, Struct3MaskB)
+	require.NoError(t, rd.Err())
+	require.Equal(t, &Struct3{B: "b", __B: true}, s)
+
+	next, err := bstruct.Marshal(&struct3Next{A: 1, C: []string{"x"}, D: "d", E: []uint16{1}})
+	require.NoError(t, err)
+	s = &Struct3{}
+	rd = bstruct.NewReader(next)
+	s.DecodeFields(rd, Struct3MaskC)
+	require.NoError(t, rd.Err())
+	require.Equal(t, &Struct3{C: []string{"x"}}, s)
+}
+
+func TestMasked(t *testing.T) {
+	cur := &Struct1{
+		A:                    true,
+		D:                    "old",
+		G:                    []string{"1", "3"},
+		E:                    []Slice1{{E: "1"}},
+		__fieldGerrrccontrol: true,
+		fieldGerrrccontrol:   true,
+	}
+	next := &Struct1{A: true, D: "new", E: []Slice1{{E: "2"}}}
+	mask := Struct1MaskD | Struct1MaskG | Struct1MaskFieldGerrrccontrol
+
+	wt := bstruct.NewWriter()
+	next.EncodeMasked(wt, mask)
+	require.Less(t, wt.Pos(), len(bstruct.Encode(next)))
+
+	patched := *cur
+	rd := bstruct.NewReader(wt.Data())
+	require.Equal(t, mask, patched.DecodeMasked(rd))
+	require.NoError(t, rd.Err())
+	require.Zero(t, rd.Remaining())
+	require.Equal(t, &Struct1{
+		A: true,
+		D: "new",
+		E: []Slice1{{E: "1"}},
+	}, &patched)
+
+	var patch Struct1
+	rd = bstruct.NewReader(wt.Data())
+	got := patch.DecodeMasked(rd)
+	require.NoError(t, rd.Err())
+	merged := *cur
+	merged.Merge(&patch, got)
+	require.Equal(t, patched, merged)
+
+	rd = bstruct.NewReader([]byte{0x80, 0x01})
+	patch.DecodeMasked(rd)
+	require.ErrorIs(t, rd.Err(), bstruct.ErrUnknownFields)
+
+	s := &Struct3{A: 1, B: "b", C: []string{"c"}}
+	s.__B = true
+	wt = bstruct.NewWriter()
+	s.EncodeMasked(wt, Struct3MaskAll)
+	u := &Struct3{}
+	rd = bstruct.NewReader(wt.Data())
+	require.Equal(t, Struct3MaskAll, u.DecodeMasked(rd))
+	require.NoError(t, rd.Err())
+	require.Equal(t, s, u)
+
+	// the mask on the wire holds the bit of id 4
+	wt = bstruct.NewWriter()
+	s.EncodeMasked(wt, Struct3MaskC)
+	require.Equal(t, byte(1<<3), wt.Data()[0])
+}
@@ -18,7 +18,7 @@ func main() {
 	flag.Parse()
 
 	buf := new(bytes.Buffer)
-	enc := NewBuilder().Getter(true).Setter(true)
+	enc := NewBuilder().Getter(true).Setter(true).Binary(true).Stream(true).Registry(true).View(true).Masks(true)
 	New(FieldStruct).
 		Reg(enc, "Struct1").
 		Comment("Struct1 is fff").
@@ -37,6 +37,20 @@ func main() {
 				Add("E", "", false, NewString())),
 		).
 		Add("fieldGerrrccontrol", "", true, New(FieldBool))
+	New(FieldStruct).
+		Reg(enc, "Struct3").
+		Comment("Struct3 is evolvable").
+		TypeID(3).
+		KeepUnknown().
+		AddID(1, "A", "", false, New(FieldUint32)).
+		Reserve(2).
+		AddID(3, "B", "", true, NewString()).
+		AddID(4, "C", "", false, NewSlice(NewString()))
+	NewService("Echo").
+		Comment("Echo is served in the tests").
+		Method("Echo", "Echo returns the request", enc.Lookup("Struct1"), enc.Lookup("Struct1")).
+		Method("Count", "Count fills A with the length of C", enc.Lookup("Struct3"), enc.Lookup("Struct3")).
+		Reg(enc)
 	enc.Process()
 	enc.Print(buf, *pak)
 	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
@@ -1,7 +1,9 @@
 package bstruct
 
 import (
+	"fmt"
 	"go/ast"
+	"hash/fnv"
 )
 
 type Coder func(rdwt ast.Expr, ptr ast.Expr, s *Field) []ast.Stmt
@@ -66,6 +68,8 @@ func (ft FieldType) String() string {
 		return "slice"
 	case FieldStruct:
 		return "struct"
+	case FieldCustom:
+		return "custom"
 	default:
 		return "invalid"
 	}
@@ -95,6 +99,7 @@ type StructField struct {
 	strucName string
 	comment   string
 	optional  bool
+	id        uint
 }
 
 type Field struct {
@@ -103,10 +108,14 @@ type Field struct {
 	comment  string
 	typ      FieldType
 	virtual  bool
+	typeID   uint64
 	// FieldSlice
 	sliceType *Field
 	// FieldStruct
 	strucFields []StructField
+	evolvable   bool
+	unknown     bool
+	reserved    []uint
 	// FieldCustom
 	custyp ast.Expr
 	cusenc Coder
@@ -148,16 +157,149 @@ func NewCustom(typ ast.Expr, enc, dec Coder) *Field {
 	}
 }
 
+// Add appends a field, its id is one above the highest id used so far.
 func (b *Field) Add(name, comment string, optional bool, field *Field) *Field {
+	id := uint(1)
+	for _, f := range b.strucFields {
+		if f.id >= id {
+			id = f.id + 1
+		}
+	}
+	for b.isReserved(id) {
+		id++
+	}
+	return b.AddID(id, name, comment, optional, field)
+}
+
+// AddID appends a field with an explicit id, which identifies the field on
+// the wire of an evolvable struct.
+func (b *Field) AddID(id uint, name, comment string, optional bool, field *Field) *Field {
+	if id == 0 {
+		panic("field id can not be 0")
+	}
+	if b.isReserved(id) {
+		panic(fmt.Sprintf("field id %d is reserved", id))
+	}
+	for _, f := range b.strucFields {
+		if f.id == id {
+			panic(fmt.Sprintf("field id %d is used by %s", id, f.strucName))
+		}
+	}
 	b.strucFields = append(b.strucFields, StructField{
 		comment:   comment,
 		optional:  optional,
 		strucName: name,
+		id:        id,
 		Field:     field,
 	})
 	return b
 }
 
+// Evolvable switches a struct from the positional layout to tagged fields,
+// so that fields can be added and removed without breaking old data.
+func (b *Field) Evolvable() *Field {
+	b.evolvable = true
+	return b
+}
+
+// KeepUnknown makes an evolvable struct carry the fields it does not know
+// in an unexported buffer, Encode writes them back verbatim.
+func (b *Field) KeepUnknown() *Field {
+	b.evolvable = true
+	b.unknown = true
+	return b
+}
+
+// Reserve marks the ids of removed fields, they are skipped on decoding and
+// can not be reused.
+func (b *Field) Reserve(ids ...uint) *Field {
+	for _, id := range ids {
+		if _, ok := b.fieldByID(id); ok {
+			panic(fmt.Sprintf("field id %d is in use", id))
+		}
+	}
+	b.reserved = append(b.reserved, ids...)
+	return b
+}
+
+func (b *Field) isReserved(id uint) bool {
+	for _, r := range b.reserved {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Field) fieldByID(id uint) (StructField, bool) {
+	for _, f := range b.strucFields {
+		if f.id == id {
+			return f, true
+		}
+	}
+	return StructField{}, false
+}
+
+// wire is the wire type of s inside an evolvable struct.
+func (s *Field) wire() int {
+	switch s.typ.Size() {
+	case 1:
+		return Wire8
+	case 2:
+		return Wire16
+	case 4:
+		return Wire32
+	case 8:
+		return Wire64
+	default:
+		return WireBytes
+	}
+}
+
+// minSize is the least number of bytes a value of s is encoded to. Custom
+// coders may write nothing.
+func (s *Field) minSize() int {
+	switch {
+	case s.typ.IsPrimitive():
+		return int(s.typ.Size())
+	case s.typ.IsType(FieldStruct) && !s.evolvable:
+		size := 0
+		for _, field := range s.strucFields {
+			if field.optional {
+				size++
+			} else {
+				size += field.minSize()
+			}
+		}
+		return size
+	case s.typ.IsType(FieldCustom):
+		return 0
+	default:
+		// lengths and end tags
+		return 1
+	}
+}
+
+// TypeID identifies the type in messages of EncodeAny. Without it, the id is
+// derived from the type name, so renaming the type changes it.
+func (b *Field) TypeID(id uint64) *Field {
+	if id == 0 {
+		panic("type id 0 is reserved")
+	}
+	b.typeID = id
+	return b
+}
+
+// messageID is the id written by EncodeAny.
+func (b *Field) messageID() uint64 {
+	if b.typeID != 0 {
+		return b.typeID
+	}
+	h := fnv.New64a()
+	h.Write([]byte(b.typename))
+	return h.Sum64()
+}
+
 func (b *Field) Comment(comment string) *Field {
 	b.comment = comment
 	return b
@@ -16,23 +16,23 @@ func TestMain(t *testing.T) {
 	buf := new(strings.Builder)
 	struc := New(FieldStruct).
 		Reg(enc, "Struct1").
-		Add("A", New(FieldBool)).
-		Add("B", NewSlice(New(FieldBool))).
-		Add("C",
+		Add("A", "", false, New(FieldBool)).
+		Add("B", "", false, NewSlice(New(FieldBool))).
+		Add("C", "", false,
 			New(FieldStruct).
 				Reg(enc, "Struct2").
-				Add("A", New(FieldBool)),
+				Add("A", "", false, New(FieldBool)),
 		).
-		Add("D", NewString()).
-		Add("G", NewSlice(NewString())).
-		Add("E",
+		Add("D", "", false, NewString()).
+		Add("G", "", false, NewSlice(NewString())).
+		Add("E", "", false,
 			NewSlice(New(FieldStruct).
-				Add("E", NewString())).
+				Add("E", "", false, NewString())).
 				Reg(enc, "Slice1"),
 		).
-		Add("F", New(FieldBool))
+		Add("F", "", false, New(FieldBool))
 	require.NotNil(t, struc)
 	enc.Process()
-	require.NoError(t, enc.Print(buf))
-	fmt.Printf(buf.String())
+	require.NoError(t, enc.Print(buf, "main"))
+	fmt.Print(buf.String())
 }
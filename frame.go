@@ -0,0 +1,282 @@
+package bstruct
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var ErrFrameTooLarge = errors.New("bstruct: frame too large")
+
+// WriteFrame writes data prefixed by its uvarint length.
+func WriteFrame(w io.Writer, data []byte) (int64, error) {
+	var buf [binary.MaxVarintLen64]byte
+	n, err := w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(data)
+	return int64(n + m), err
+}
+
+// ReadFrame reads a frame written by WriteFrame, consuming nothing past it.
+// It returns io.EOF only if r ends before the frame starts.
+func ReadFrame(r io.Reader) ([]byte, int64, error) {
+	length, n, err := readUvarint(r)
+	if err != nil {
+		return nil, n, err
+	}
+	return readBody(r, length, n)
+}
+
+// readBody reads length bytes, growing the buffer as data arrives rather
+// than trusting length upfront.
+func readBody(r io.Reader, length uint64, n int64) ([]byte, int64, error) {
+	var buf bytes.Buffer
+	m, err := buf.ReadFrom(io.LimitReader(r, int64(length)))
+	n += m
+	if err != nil {
+		return nil, n, err
+	}
+	if uint64(m) != length {
+		return nil, n, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), n, nil
+}
+
+func readUvarint(r io.Reader) (uint64, int64, error) {
+	var x uint64
+	var b [1]byte
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, int64(i), err
+		}
+		if b[0] < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b[0] > 1 {
+				break
+			}
+			// padded lengths are never written, but mark compressed streams
+			if i > 0 && b[0] == 0 {
+				return 0, int64(i + 1), ErrInvalidLen
+			}
+			return x | uint64(b[0])<<(7*i), int64(i + 1), nil
+		}
+		x |= uint64(b[0]&0x7f) << (7 * i)
+	}
+	return 0, binary.MaxVarintLen64, ErrFrameTooLarge
+}
+
+// DefaultMaxFrame is the frame size limit of new FrameWriters and
+// FrameReaders.
+const DefaultMaxFrame = 16 << 20
+
+// FrameWriter writes one frame per Codec, reusing its buffer between frames.
+// Frames are prefixed by their uvarint length, or a 4 byte little-endian one
+// once Fixed is set, as WriteFrame does.
+type FrameWriter struct {
+	w          io.Writer
+	wt         *Writer
+	fixed      bool
+	max        int
+	threshold  int
+	started    bool
+	compressed bool
+	buf        []byte
+}
+
+// Compressed streams start with a length no FrameWriter writes, telling
+// FrameReaders that every frame holds the flag byte of AppendCompressed.
+var (
+	varintMarker = []byte{0x80, 0x00}
+	fixedMarker  = []byte{0xff, 0xff, 0xff, 0xff}
+)
+
+func streamMarker(fixed bool) []byte {
+	if fixed {
+		return fixedMarker
+	}
+	return varintMarker
+}
+
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w, wt: NewWriter(), max: DefaultMaxFrame, threshold: -1}
+}
+
+func (f *FrameWriter) Fixed(fixed bool) *FrameWriter {
+	f.fixed = fixed
+	return f
+}
+
+// MaxSize limits the length of frames, Encode fails with ErrFrameTooLarge
+// beyond it.
+func (f *FrameWriter) MaxSize(n int) *FrameWriter {
+	f.max = n
+	return f
+}
+
+// Compress deflates frames of at least threshold bytes, see
+// AppendCompressed. It takes effect on the first Encode only, which marks the
+// stream as compressed. Such streams are only read by FrameReaders.
+func (f *FrameWriter) Compress(threshold int) *FrameWriter {
+	f.threshold = threshold
+	return f
+}
+
+// Encode writes v as a single frame, with a single Write call.
+func (f *FrameWriter) Encode(v Codec) error {
+	// room for the marker and the prefix, filled in backwards once the
+	// length is known
+	const room = binary.MaxVarintLen64 + 4
+	f.wt.Reset()
+	f.wt.grow(room)
+	f.wt.pos = room
+	v.Encode(f.wt)
+	frame := f.wt.Data()
+	if !f.started {
+		f.compressed = f.threshold >= 0
+	}
+	if f.compressed {
+		f.buf = AppendCompressed(append(f.buf[:0], frame[:room]...), frame[room:], f.threshold)
+		frame = f.buf
+	}
+
+	length := len(frame) - room
+	if length > f.max || f.fixed && uint64(length) >= math.MaxUint32 {
+		return ErrFrameTooLarge
+	}
+	var hdr [binary.MaxVarintLen64]byte
+	n := 4
+	if f.fixed {
+		binary.LittleEndian.PutUint32(hdr[:], uint32(length))
+	} else {
+		n = binary.PutUvarint(hdr[:], uint64(length))
+	}
+	start := room - n
+	copy(frame[start:], hdr[:n])
+	if !f.started && f.compressed {
+		marker := streamMarker(f.fixed)
+		start -= len(marker)
+		copy(frame[start:], marker)
+	}
+	f.started = true
+	_, err := f.w.Write(frame[start:])
+	return err
+}
+
+// FrameReader reads the frames of a FrameWriter configured alike, or of
+// WriteFrame, and tells compressed streams by their marker. The frame
+// buffer is reused, so strings and primitive slices of a decoded value are
+// only valid until the next Decode.
+type FrameReader struct {
+	r          *bufio.Reader
+	buf        []byte
+	fixed      bool
+	max        int
+	started    bool
+	compressed bool
+	inflated   []byte
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r), max: DefaultMaxFrame}
+}
+
+func (f *FrameReader) Fixed(fixed bool) *FrameReader {
+	f.fixed = fixed
+	return f
+}
+
+// MaxSize limits the length of frames, Decode fails with ErrFrameTooLarge
+// beyond it, before reading the frame.
+func (f *FrameReader) MaxSize(n int) *FrameReader {
+	f.max = n
+	return f
+}
+
+// Decode reads the next frame into v. It returns io.EOF only if the stream
+// ends between frames.
+func (f *FrameReader) Decode(v Codec) error {
+	data, err := f.next()
+	if err != nil {
+		return err
+	}
+	rd := NewReader(data)
+	v.Decode(rd)
+	return rd.Err()
+}
+
+// next reads the payload of the next frame, valid until the next call.
+func (f *FrameReader) next() ([]byte, error) {
+	if !f.started {
+		f.started = true
+		f.compressed = f.marked()
+	}
+	var length uint64
+	if f.fixed {
+		var hdr [4]byte
+		if n, err := io.ReadFull(f.r, hdr[:]); err != nil {
+			if n > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		length = uint64(binary.LittleEndian.Uint32(hdr[:]))
+	} else {
+		var err error
+		if length, _, err = readUvarint(f.r); err != nil {
+			return nil, err
+		}
+	}
+	if length > uint64(f.max) {
+		return nil, ErrFrameTooLarge
+	}
+
+	if uint64(cap(f.buf)) < length {
+		f.buf = make([]byte, length)
+	}
+	f.buf = f.buf[:length]
+	if _, err := io.ReadFull(f.r, f.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	data := f.buf
+	if f.compressed {
+		// raw frames are decoded in place, compressed ones may not inflate
+		// beyond MaxSize either
+		switch {
+		case length == 0:
+			return nil, ErrShortData
+		case f.buf[0] == flagRaw:
+			data = f.buf[1:]
+		default:
+			var err error
+			if f.inflated, err = AppendDecompressed(f.inflated[:0], f.buf, f.max); err != nil {
+				return nil, err
+			}
+			data = f.inflated
+		}
+	}
+	return data, nil
+}
+
+// marked consumes the marker of a compressed stream. Peeking never waits for
+// more than the prefix of the first frame.
+func (f *FrameReader) marked() bool {
+	marker := streamMarker(f.fixed)
+	if head, _ := f.r.Peek(1); len(head) == 0 || head[0] != marker[0] {
+		return false
+	}
+	if head, _ := f.r.Peek(len(marker)); !bytes.Equal(head, marker) {
+		return false
+	}
+	_, _ = f.r.Discard(len(marker))
+	return true
+}
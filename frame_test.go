@@ -0,0 +1,38 @@
+package bstruct
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := WriteFrame(&buf, bytes.Repeat([]byte{7}, 200))
+	require.NoError(t, err)
+	require.Equal(t, int64(202), n)
+	_, err = WriteFrame(&buf, nil)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	r := bytes.NewReader(data)
+	frame, n, err := ReadFrame(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(202), n)
+	require.Len(t, frame, 200)
+	frame, n, err = ReadFrame(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+	require.Empty(t, frame)
+	_, _, err = ReadFrame(r)
+	require.ErrorIs(t, err, io.EOF)
+
+	_, _, err = ReadFrame(bytes.NewReader(data[:1]))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, _, err = ReadFrame(bytes.NewReader(data[:100]))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, _, err = ReadFrame(bytes.NewReader(bytes.Repeat([]byte{0xff}, 11)))
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+}
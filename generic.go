@@ -0,0 +1,40 @@
+package bstruct
+
+import "sync"
+
+// Codec is implemented by the pointers of generated types.
+type Codec interface {
+	Encode(*Writer)
+	Decode(*Reader)
+}
+
+var writers = sync.Pool{
+	New: func() any { return NewWriter() },
+}
+
+// Reset empties w, keeping its buffer.
+func (w *Writer) Reset() {
+	w.pos = 0
+}
+
+// Encode encodes v with a pooled Writer into a newly allocated slice.
+func Encode[T Codec](v T) []byte {
+	wt := writers.Get().(*Writer)
+	v.Encode(wt)
+	data := append([]byte(nil), wt.Data()...)
+	wt.Reset()
+	writers.Put(wt)
+	return data
+}
+
+// Decode decodes a T from data. Strings and primitive slices of the result
+// alias data.
+func Decode[T any, PT interface {
+	*T
+	Codec
+}](data []byte) (T, error) {
+	var v T
+	rd := NewReader(data)
+	PT(&v).Decode(rd)
+	return v, rd.Err()
+}
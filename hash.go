@@ -0,0 +1,43 @@
+package bstruct
+
+import (
+	"fmt"
+	"go/types"
+	"hash/fnv"
+	"io"
+)
+
+// SchemaHash is a FNV-1a hash over everything of the Field tree that shapes
+// the wire format or the generated fields: types, field names, ids and flags.
+// Comments and names of registered types are left out.
+func (s *Field) SchemaHash() uint64 {
+	h := fnv.New64a()
+	s.canonical(h, make(map[*Field]int))
+	return h.Sum64()
+}
+
+func (s *Field) canonical(w io.Writer, seen map[*Field]int) {
+	if n, ok := seen[s]; ok {
+		fmt.Fprintf(w, "#%d", n)
+		return
+	}
+	seen[s] = len(seen)
+
+	fmt.Fprint(w, s.typ)
+	switch {
+	case s.typ.IsType(FieldSlice) || s.typ.IsType(FieldString):
+		fmt.Fprint(w, "[")
+		s.sliceType.canonical(w, seen)
+		fmt.Fprint(w, "]")
+	case s.typ.IsType(FieldStruct):
+		fmt.Fprintf(w, "{%t,%t,%v;", s.evolvable, s.unknown, s.reserved)
+		for _, field := range s.strucFields {
+			fmt.Fprintf(w, "%d,%s,%t,", field.id, field.strucName, field.optional)
+			field.canonical(w, seen)
+			fmt.Fprint(w, ";")
+		}
+		fmt.Fprint(w, "}")
+	case s.typ.IsType(FieldCustom):
+		fmt.Fprintf(w, "(%s)", types.ExprString(s.custyp))
+	}
+}
@@ -0,0 +1,45 @@
+package bstruct
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaHash(t *testing.T) {
+	mk := func(comment string) *Field {
+		return New(FieldStruct).
+			Add("A", comment, false, New(FieldBool)).
+			Add("B", "", true, NewSlice(NewString()))
+	}
+	require.Equal(t, mk("").SchemaHash(), mk("other").SchemaHash())
+	require.NotEqual(t, mk("").SchemaHash(), mk("").Add("C", "", false, New(FieldInt8)).SchemaHash())
+	require.NotEqual(t, mk("").SchemaHash(), mk("").Evolvable().SchemaHash())
+
+	self := New(FieldStruct)
+	self.Add("A", "", false, NewSlice(self))
+	require.NotZero(t, self.SchemaHash())
+}
+
+func TestEnvelope(t *testing.T) {
+	enc := NewBuilder().Envelope(true)
+	f := New(FieldStruct).
+		Reg(enc, "Struct1").
+		Add("A", "", false, New(FieldStruct).Reg(enc, "Struct2").Add("A", "", false, New(FieldBool)))
+	enc.Process()
+	buf := new(strings.Builder)
+	require.NoError(t, enc.Print(buf, "main"))
+	require.Contains(t, buf.String(), "v.A.encode(wt)")
+	require.Contains(t, buf.String(), "wt.WriteHash(Struct1SchemaHash)")
+	require.Contains(t, buf.String(), "if rd.CheckHash(Struct1SchemaHash) {")
+
+	wt := NewWriter()
+	wt.WriteHash(f.SchemaHash())
+	rd := NewReader(wt.Data())
+	require.True(t, rd.CheckHash(f.SchemaHash()))
+	rd = NewReader(wt.Data())
+	require.False(t, rd.CheckHash(f.SchemaHash()+1))
+	require.True(t, errors.Is(rd.Err(), ErrSchemaMismatch))
+}
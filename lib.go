@@ -2,6 +2,8 @@ package bstruct
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"unsafe"
 )
 
@@ -9,9 +11,32 @@ import (
 //go:linkname memmove runtime.memmove
 func memmove(to, from unsafe.Pointer, n uintptr)
 
+var (
+	ErrShortData      = errors.New("bstruct: unexpected end of data")
+	ErrInvalidLen     = errors.New("bstruct: invalid length")
+	ErrInvalidWire    = errors.New("bstruct: invalid wire type")
+	ErrSchemaMismatch = errors.New("bstruct: schema mismatch")
+	ErrUnknownFields  = errors.New("bstruct: mask selects unknown fields")
+)
+
+// Wire types of the fields in an evolvable struct. Every field is prefixed
+// by a tag of its id and wire type, WireBytes values are length-delimited so
+// that readers can skip fields they do not know. WireEnd closes the struct.
+const (
+	WireEnd = iota
+	Wire8
+	Wire16
+	Wire32
+	Wire64
+	WireBytes
+)
+
+// Reader keeps the first error it runs into, every later read is a no-op.
+// Values decoded from a failed Reader must be discarded.
 type Reader struct {
 	data []byte
 	pos  int
+	err  error
 }
 
 func NewReader(data []byte) *Reader {
@@ -26,23 +51,208 @@ func (r *Reader) Pos() int {
 	return r.pos
 }
 
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// ReadLen reads a length of bytes, or of elements taking one byte at least,
+// which can not run past the end of the data.
 func (r *Reader) ReadLen() int {
+	return r.ReadCount(1)
+}
+
+// ReadCount reads the length of a slice whose elements take size bytes at
+// least. Lengths of elements taking no bytes are not bounded by the data.
+func (r *Reader) ReadCount(size int) int {
+	if r.err != nil {
+		return 0
+	}
 	l, off := binary.Varint(r.data[r.pos:])
+	if off == 0 {
+		r.fail(ErrShortData)
+		return 0
+	}
+	if off < 0 {
+		r.fail(ErrInvalidLen)
+		return 0
+	}
 	r.pos += off
+	if l < 0 || size > 0 && l > int64((len(r.data)-r.pos)/size) {
+		r.fail(ErrInvalidLen)
+		return 0
+	}
 	return int(l)
 }
 
 func (r *Reader) Copy(ptr unsafe.Pointer, length int) {
+	if r.err != nil || length == 0 {
+		return
+	}
+	if length > len(r.data)-r.pos {
+		r.fail(ErrShortData)
+		return
+	}
 	memmove(ptr, unsafe.Pointer(&r.data[r.pos]), uintptr(length))
 	r.pos += length
 }
 
+// Read returns a pointer aliasing the next length bytes, or 0 on failure.
 func (r *Reader) Read(length int) uintptr {
+	if r.err != nil || length == 0 {
+		return 0
+	}
+	if length > len(r.data)-r.pos {
+		r.fail(ErrShortData)
+		return 0
+	}
 	ptr := uintptr(unsafe.Pointer(&r.data[r.pos]))
 	r.pos += length
 	return ptr
 }
 
+// ReadEnd reads the length of a value, returning the position it ends at.
+func (r *Reader) ReadEnd() int {
+	length := r.ReadLen()
+	return r.pos + length
+}
+
+// SkipTo moves to end, as returned by ReadEnd, past what is left of the
+// value. Values running past their end fail with ErrInvalidLen.
+func (r *Reader) SkipTo(end int) {
+	if r.err != nil {
+		return
+	}
+	if r.pos > end {
+		r.fail(ErrInvalidLen)
+		return
+	}
+	r.pos = end
+}
+
+func (r *Reader) Skip(length int) {
+	if r.err != nil {
+		return
+	}
+	if length < 0 || length > len(r.data)-r.pos {
+		r.fail(ErrShortData)
+		return
+	}
+	r.pos += length
+}
+
+func (r *Reader) Remaining() int {
+	return len(r.data) - r.pos
+}
+
+// Seek moves to the absolute position pos, which may be anywhere within the
+// data.
+func (r *Reader) Seek(pos int) {
+	if r.err != nil {
+		return
+	}
+	if pos < 0 || pos > len(r.data) {
+		r.fail(ErrShortData)
+		return
+	}
+	r.pos = pos
+}
+
+// ReadMask reads a field mask, failing with ErrUnknownFields if it selects
+// fields outside of all.
+func (r *Reader) ReadMask(all uint64) uint64 {
+	mask := r.ReadUvarint()
+	if mask&^all != 0 {
+		r.fail(fmt.Errorf("%w: %#x", ErrUnknownFields, mask&^all))
+		return 0
+	}
+	return mask
+}
+
+// ReadTag returns the id and wire type of the next field, or WireEnd once
+// the Reader has failed.
+func (r *Reader) ReadTag() (int, int) {
+	if r.err != nil {
+		return 0, WireEnd
+	}
+	tag, off := binary.Uvarint(r.data[r.pos:])
+	if off <= 0 {
+		r.fail(ErrShortData)
+		return 0, WireEnd
+	}
+	r.pos += off
+	return int(tag >> 3), int(tag & 7)
+}
+
+func (r *Reader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, off := binary.Uvarint(r.data[r.pos:])
+	if off == 0 {
+		r.fail(ErrShortData)
+		return 0
+	}
+	if off < 0 {
+		r.fail(ErrInvalidLen)
+		return 0
+	}
+	r.pos += off
+	return x
+}
+
+// SkipWire skips a value of the given wire type.
+func (r *Reader) SkipWire(wire int) {
+	switch wire {
+	case Wire8:
+		r.Skip(1)
+	case Wire16:
+		r.Skip(2)
+	case Wire32:
+		r.Skip(4)
+	case Wire64:
+		r.Skip(8)
+	case WireBytes:
+		r.Skip(r.ReadLen())
+	default:
+		r.fail(ErrInvalidWire)
+	}
+}
+
+// ExpectWire reports whether a field came with the wire type the schema
+// wants, otherwise the value is skipped.
+func (r *Reader) ExpectWire(got, want int) bool {
+	if got == want {
+		return true
+	}
+	r.SkipWire(got)
+	return false
+}
+
+// CheckHash reads the schema hash written by WriteHash, and fails with
+// ErrSchemaMismatch unless it is the expected one.
+func (r *Reader) CheckHash(hash uint64) bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.data)-r.pos < 8 {
+		r.fail(ErrShortData)
+		return false
+	}
+	got := binary.LittleEndian.Uint64(r.data[r.pos:])
+	if got != hash {
+		r.fail(fmt.Errorf("%w: got %#016x, want %#016x", ErrSchemaMismatch, got, hash))
+		return false
+	}
+	r.pos += 8
+	return true
+}
+
 type Writer struct {
 	data []byte
 	pos  int
@@ -52,26 +262,71 @@ func NewWriter() *Writer {
 	return &Writer{data: make([]byte, 16)}
 }
 
+// NewAppendWriter returns a Writer appending to dst.
+func NewAppendWriter(dst []byte) *Writer {
+	return &Writer{data: dst[:cap(dst)], pos: len(dst)}
+}
+
 func (w *Writer) grow(length int) {
 	rem := len(w.data) - w.pos
 	if rem >= length {
-		w.data = w.data[:len(w.data)]
 		return
 	}
 
-	w.data = append(w.data, make([]byte, cap(w.data))...)
+	w.data = append(w.data, make([]byte, length-rem+cap(w.data))...)
 }
 
+// Data returns the bytes written so far.
 func (w *Writer) Data() []byte {
-	return w.data
+	return w.data[:w.pos]
+}
+
+func (w *Writer) Pos() int {
+	return w.pos
+}
+
+func (w *Writer) WriteTag(id, wire int) {
+	w.grow(binary.MaxVarintLen64)
+	w.pos += binary.PutUvarint(w.data[w.pos:], uint64(id)<<3|uint64(wire))
+}
+
+// PrefixLen inserts the length of everything written since start in front of
+// it, as read back by ReadLen.
+func (w *Writer) PrefixLen(start int) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], int64(w.pos-start))
+	w.grow(n)
+	copy(w.data[start+n:], w.data[start:w.pos])
+	copy(w.data[start:], buf[:n])
+	w.pos += n
+}
+
+func (w *Writer) WriteHash(hash uint64) {
+	w.grow(8)
+	binary.LittleEndian.PutUint64(w.data[w.pos:], hash)
+	w.pos += 8
+}
+
+func (w *Writer) WriteUvarint(x uint64) {
+	w.grow(binary.MaxVarintLen64)
+	w.pos += binary.PutUvarint(w.data[w.pos:], x)
 }
 
 func (w *Writer) WriteLen(length int) {
-	w.grow(9)
+	w.grow(binary.MaxVarintLen64)
 	w.pos += binary.PutVarint(w.data[w.pos:], int64(length))
 }
 
+func (w *Writer) Write(p []byte) (int, error) {
+	w.grow(len(p))
+	w.pos += copy(w.data[w.pos:], p)
+	return len(p), nil
+}
+
 func (w *Writer) Copy(ptr unsafe.Pointer, length int) {
+	if length == 0 {
+		return
+	}
 	w.grow(length)
 	memmove(unsafe.Pointer(&w.data[w.pos]), ptr, uintptr(length))
 	w.pos += length
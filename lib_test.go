@@ -0,0 +1,26 @@
+package bstruct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderSeek(t *testing.T) {
+	rd := NewReader([]byte{1, 2, 3, 4})
+	rd.Skip(3)
+	require.Equal(t, 1, rd.Remaining())
+	rd.Seek(1)
+	require.Equal(t, 3, rd.Remaining())
+	rd.Seek(4)
+	require.Equal(t, 0, rd.Remaining())
+	require.NoError(t, rd.Err())
+
+	rd.Seek(5)
+	require.ErrorIs(t, rd.Err(), ErrShortData)
+	rd = NewReader([]byte{1})
+	rd.Skip(2)
+	require.ErrorIs(t, rd.Err(), ErrShortData)
+	rd.Seek(1)
+	require.Equal(t, 0, rd.Pos())
+}
@@ -0,0 +1,278 @@
+package bstruct
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// Masks emits a XMask bitset type for every struct X, with a constant per
+// field, and methods working on the selected fields only: DecodeFields,
+// EncodeMasked writing the mask and the selected fields, DecodeMasked reading
+// them back and Merge copying them between values. Structs with masks can not
+// have more than 64 fields, or evolvable ones ids above 64: their bits are
+// 1<<(id-1), so that masks stay valid as fields are added and removed.
+func (e *Builder) Masks(f bool) *Builder {
+	e.masks = f
+	return e
+}
+
+func maskType(name string) string {
+	return fmt.Sprintf("%sMask", name)
+}
+
+func maskConst(name string, field StructField) string {
+	return fmt.Sprintf("%sMask%s", name, capitalize(field.strucName))
+}
+
+func (e *Builder) maskDecl(el *Field) (decls []ast.Decl) {
+	if len(el.strucFields) > 64 {
+		panic(fmt.Sprintf("%s has more than 64 fields for a mask", el.typename))
+	}
+
+	typ := maskType(el.typename)
+	decls = append(decls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Doc: e.commentGroup(fmt.Sprintf("%s selects fields of %s.", typ, el.typename)),
+		Specs: []ast.Spec{&ast.TypeSpec{
+			Name: ast.NewIdent(typ),
+			Type: newIdent("uint64"),
+		}},
+	})
+
+	// bits of evolvable structs follow the field ids, to stay put as fields
+	// come and go
+	names := declNames{typ + "All": "the mask of all fields"}
+	consts := &ast.GenDecl{Tok: token.CONST, Lparen: 1}
+	var all ast.Expr = intLit(0)
+	for i, field := range el.strucFields {
+		name := maskConst(el.typename, field)
+		names.claim(name, fmt.Sprintf("%s.%s", el.typename, field.strucName))
+		bit := uint(i)
+		if el.evolvable {
+			if field.id > 64 {
+				panic(fmt.Sprintf("%s.%s has id %d above 64 for a mask", el.typename, field.strucName, field.id))
+			}
+			bit = field.id - 1
+		}
+		consts.Specs = append(consts.Specs, &ast.ValueSpec{
+			Names:  []*ast.Ident{ast.NewIdent(name)},
+			Type:   ast.NewIdent(typ),
+			Values: []ast.Expr{&ast.BinaryExpr{X: intLit(1), Op: token.SHL, Y: intLit(bit)}},
+		})
+		if i == 0 {
+			all = ast.NewIdent(name)
+		} else {
+			all = &ast.BinaryExpr{X: all, Op: token.OR, Y: ast.NewIdent(name)}
+		}
+	}
+	consts.Specs = append(consts.Specs, &ast.ValueSpec{
+		Names:  []*ast.Ident{ast.NewIdent(fmt.Sprintf("%sAll", typ))},
+		Type:   ast.NewIdent(typ),
+		Values: []ast.Expr{all},
+	})
+	decls = append(decls, consts)
+
+	decls = append(decls, e.decodeFieldsDecl(el), e.encodeMaskedDecl(el), e.decodeMaskedDecl(el), e.mergeDecl(el))
+	return
+}
+
+func (e *Builder) selected(mask ast.Expr, name string, field StructField) ast.Expr {
+	return &ast.BinaryExpr{
+		X:  &ast.ParenExpr{X: &ast.BinaryExpr{X: mask, Op: token.AND, Y: ast.NewIdent(maskConst(name, field))}},
+		Op: token.NEQ,
+		Y:  intLit(0),
+	}
+}
+
+// decodeFieldsDecl decodes the fields selected by a mask and skips the
+// others, which are left as they are. Unknown fields are not kept.
+func (e *Builder) decodeFieldsDecl(el *Field) ast.Decl {
+	reader := ast.NewIdent("rd")
+	mask := ast.NewIdent("mask")
+	fn, ptr := e.getFunc(el, "DecodeFields")
+	fn.Type.Params.List = append(fn.Type.Params.List,
+		newParam(reader.Name, readerType),
+		newParam(mask.Name, ast.NewIdent(maskType(el.typename))),
+	)
+	if e.envelope {
+		fn.Body.List = append(fn.Body.List, &ast.IfStmt{
+			Cond: &ast.UnaryExpr{Op: token.NOT, X: newCall(newSel(reader, "CheckHash"), ast.NewIdent(fmt.Sprintf("%sSchemaHash", el.typename)))},
+			Body: &ast.BlockStmt{List: []ast.Stmt{newReturn()}},
+		})
+	}
+
+	if !el.evolvable {
+		for _, field := range el.strucFields {
+			dec := e.decField(reader, newSel(ptr, field.strucName), field.Field)
+			skip := e.skipField(reader, field.Field)
+			if field.optional {
+				// the flag is read into the value, as Decode does
+				has := newSel(ptr, newOpt(field.strucName))
+				dec = append(e.decPrim(reader, has, New(FieldBool)), &ast.IfStmt{
+					Cond: has,
+					Body: &ast.BlockStmt{List: dec},
+				})
+				skip = e.readFlag(reader, skip)
+			}
+			fn.Body.List = append(fn.Body.List, &ast.IfStmt{
+				Cond: e.selected(mask, el.typename, field),
+				Body: &ast.BlockStmt{List: dec},
+				Else: &ast.BlockStmt{List: skip},
+			})
+		}
+		return fn
+	}
+
+	id := e.newIdent()
+	wire := e.newIdent()
+	var clauses []ast.Stmt
+	for _, field := range el.strucFields {
+		var bstmts []ast.Stmt
+		if field.optional {
+			bstmts = append(bstmts, newAssign(newSel(ptr, newOpt(field.strucName)), "true"))
+		}
+		bstmts = append(bstmts, e.decWire(reader, newSel(ptr, field.strucName), field.Field)...)
+		clauses = append(clauses, &ast.CaseClause{
+			List: []ast.Expr{intLit(field.id)},
+			Body: []ast.Stmt{&ast.IfStmt{
+				Cond: newCall(newSel(reader, "ExpectWire"), wire, wireSel(field.wire())),
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.IfStmt{
+					Cond: e.selected(mask, el.typename, field),
+					Body: &ast.BlockStmt{List: bstmts},
+					Else: &ast.BlockStmt{List: []ast.Stmt{newCallST(newSel(reader, "SkipWire"), wire)}},
+				}}},
+			}},
+		})
+	}
+	clauses = append(clauses, &ast.CaseClause{Body: []ast.Stmt{newCallST(newSel(reader, "SkipWire"), wire)}})
+	fn.Body.List = append(fn.Body.List, &ast.ForStmt{
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{id, wire},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{newCall(newSel(reader, "ReadTag"))},
+			},
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{X: wire, Op: token.EQL, Y: wireSel(WireEnd)},
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}},
+			},
+			&ast.SwitchStmt{Tag: id, Body: &ast.BlockStmt{List: clauses}},
+		}},
+	})
+	return fn
+}
+
+// maskFields orders the fields of el as EncodeMasked writes them: by id for
+// evolvable structs, whose fields may be declared in any order.
+func maskFields(el *Field) []StructField {
+	fields := el.strucFields
+	if el.evolvable {
+		fields = append([]StructField(nil), fields...)
+		sort.Slice(fields, func(i, j int) bool { return fields[i].id < fields[j].id })
+	}
+	return fields
+}
+
+// encodeMaskedDecl writes the mask, then the selected fields in the
+// positional layout, whether the struct is evolvable or not. Evolvable structs
+// write their id-based bits and fields in id order, so that another version
+// of the struct reads them as long as it knows every selected id.
+func (e *Builder) encodeMaskedDecl(el *Field) ast.Decl {
+	writer := ast.NewIdent("wt")
+	mask := ast.NewIdent("mask")
+	typ := maskType(el.typename)
+	fn, ptr := e.getFunc(el, "EncodeMasked")
+	fn.Type.Params.List = append(fn.Type.Params.List,
+		newParam(writer.Name, writerType),
+		newParam(mask.Name, ast.NewIdent(typ)),
+	)
+	if e.envelope {
+		fn.Body.List = append(fn.Body.List, newCallST(newSel(writer, "WriteHash"), ast.NewIdent(fmt.Sprintf("%sSchemaHash", el.typename))))
+	}
+	fn.Body.List = append(fn.Body.List,
+		&ast.AssignStmt{Lhs: []ast.Expr{mask}, Tok: token.AND_ASSIGN, Rhs: []ast.Expr{ast.NewIdent(typ + "All")}},
+		newCallST(newSel(writer, "WriteUvarint"), newCall("uint64", mask)),
+	)
+	for _, field := range maskFields(el) {
+		enc := e.encField(writer, newSel(ptr, field.strucName), field.Field)
+		if field.optional {
+			has := newSel(ptr, newOpt(field.strucName))
+			enc = append(e.encPrim(writer, has, New(FieldBool)), &ast.IfStmt{
+				Cond: has,
+				Body: &ast.BlockStmt{List: enc},
+			})
+		}
+		fn.Body.List = append(fn.Body.List, &ast.IfStmt{
+			Cond: e.selected(mask, el.typename, field),
+			Body: &ast.BlockStmt{List: enc},
+		})
+	}
+	return fn
+}
+
+// decodeMaskedDecl reads what EncodeMasked wrote onto the value, leaving the
+// fields outside of the mask as they are, and returns the mask.
+func (e *Builder) decodeMaskedDecl(el *Field) ast.Decl {
+	reader := ast.NewIdent("rd")
+	mask := ast.NewIdent("mask")
+	typ := maskType(el.typename)
+	fn, ptr := e.getFunc(el, "DecodeMasked")
+	fn.Type.Params.List = append(fn.Type.Params.List, newParam(reader.Name, readerType))
+	fn.Type.Results.List = append(fn.Type.Results.List, newParam("", ast.NewIdent(typ)))
+	if e.envelope {
+		fn.Body.List = append(fn.Body.List, &ast.IfStmt{
+			Cond: &ast.UnaryExpr{Op: token.NOT, X: newCall(newSel(reader, "CheckHash"), ast.NewIdent(fmt.Sprintf("%sSchemaHash", el.typename)))},
+			Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(intLit(0))}},
+		})
+	}
+	// decoding leaves empty strings and slices alone, so they are reset first
+	zero := ast.NewIdent("zero")
+	fn.Body.List = append(fn.Body.List,
+		&ast.DeclStmt{Decl: &ast.GenDecl{
+			Tok:   token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{zero}, Type: ast.NewIdent(el.typename)}},
+		}},
+		newDef(mask, newCall(typ, newCall(newSel(reader, "ReadMask"), newCall("uint64", ast.NewIdent(typ+"All"))))),
+	)
+	for _, field := range maskFields(el) {
+		dec := e.decField(reader, newSel(ptr, field.strucName), field.Field)
+		if field.optional {
+			has := newSel(ptr, newOpt(field.strucName))
+			dec = append(e.decPrim(reader, has, New(FieldBool)), &ast.IfStmt{
+				Cond: has,
+				Body: &ast.BlockStmt{List: dec},
+			})
+		}
+		fn.Body.List = append(fn.Body.List, &ast.IfStmt{
+			Cond: e.selected(mask, el.typename, field),
+			Body: &ast.BlockStmt{List: append([]ast.Stmt{newAssign(newSel(ptr, field.strucName), newSel(zero, field.strucName))}, dec...)},
+		})
+	}
+	fn.Body.List = append(fn.Body.List, newReturn(mask))
+	return fn
+}
+
+// mergeDecl copies the selected fields of src, presence included. Slices are
+// shared, not copied.
+func (e *Builder) mergeDecl(el *Field) ast.Decl {
+	src := ast.NewIdent("src")
+	mask := ast.NewIdent("mask")
+	fn, ptr := e.getFunc(el, "Merge")
+	fn.Type.Params.List = append(fn.Type.Params.List,
+		newParam(src.Name, ptrTo(el.typename)),
+		newParam(mask.Name, ast.NewIdent(maskType(el.typename))),
+	)
+	for _, field := range el.strucFields {
+		body := []ast.Stmt{newAssign(newSel(ptr, field.strucName), newSel(src, field.strucName))}
+		if field.optional {
+			body = append([]ast.Stmt{newAssign(newSel(ptr, newOpt(field.strucName)), newSel(src, newOpt(field.strucName)))}, body...)
+		}
+		fn.Body.List = append(fn.Body.List, &ast.IfStmt{
+			Cond: e.selected(mask, el.typename, field),
+			Body: &ast.BlockStmt{List: body},
+		})
+	}
+	return fn
+}
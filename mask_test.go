@@ -0,0 +1,26 @@
+package bstruct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskNames(t *testing.T) {
+	for _, names := range [][]string{{"A", "All"}, {"a", "A"}} {
+		e := NewBuilder().Masks(true)
+		f := New(FieldStruct).Reg(e, "Msg")
+		for _, name := range names {
+			f.Add(name, "", false, New(FieldBool))
+		}
+		require.Panics(t, func() { e.Process() })
+	}
+
+	e := NewBuilder().Masks(true)
+	New(FieldStruct).Reg(e, "Msg").Add("All", "", false, New(FieldBool))
+	require.PanicsWithValue(t, "MsgMaskAll of Msg.All is taken by the mask of all fields", func() { e.Process() })
+
+	e = NewBuilder().Masks(true)
+	New(FieldStruct).Reg(e, "Msg").Evolvable().AddID(65, "A", "", false, New(FieldBool))
+	require.PanicsWithValue(t, "Msg.A has id 65 above 64 for a mask", func() { e.Process() })
+}
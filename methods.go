@@ -0,0 +1,133 @@
+package bstruct
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+var (
+	byteSlice = &ast.ArrayType{Elt: newIdent("byte")}
+	errorType = newIdent("error")
+)
+
+func newReturn(results ...ast.Expr) *ast.ReturnStmt {
+	return &ast.ReturnStmt{Results: results}
+}
+
+func newParam(name string, typ ast.Expr) *ast.Field {
+	if name == "" {
+		return &ast.Field{Type: typ}
+	}
+	return &ast.Field{Names: []*ast.Ident{ast.NewIdent(name)}, Type: typ}
+}
+
+// binaryDecl implements encoding.BinaryMarshaler, encoding.BinaryUnmarshaler
+// and AppendBinary on top of Encode and Decode. UnmarshalBinary copies data,
+// as the decoded value would alias it otherwise.
+func (e *Builder) binaryDecl(el *Field) (decls []ast.Decl) {
+	marshal, _ := e.getFunc(el, "MarshalBinary")
+	marshal.Type.Results.List = append(marshal.Type.Results.List, newParam("", byteSlice), newParam("", errorType))
+	marshal.Body.List = append(marshal.Body.List, newReturn(newCall(newSel("v", "AppendBinary"), newIdent("nil"))))
+
+	dst := ast.NewIdent("dst")
+	writer := ast.NewIdent("wt")
+	app, _ := e.getFunc(el, "AppendBinary")
+	app.Type.Params.List = append(app.Type.Params.List, newParam(dst.Name, byteSlice))
+	app.Type.Results.List = append(app.Type.Results.List, newParam("", byteSlice), newParam("", errorType))
+	app.Body.List = append(app.Body.List,
+		newDef(writer, newCall(newSel("bstruct", "NewAppendWriter"), dst)),
+		newCallST(newSel("v", "Encode"), writer),
+		newReturn(newCall(newSel(writer, "Data")), newIdent("nil")),
+	)
+
+	data := ast.NewIdent("data")
+	reader := ast.NewIdent("rd")
+	unmarshal, _ := e.getFunc(el, "UnmarshalBinary")
+	unmarshal.Type.Params.List = append(unmarshal.Type.Params.List, newParam(data.Name, byteSlice))
+	unmarshal.Type.Results.List = append(unmarshal.Type.Results.List, newParam("", errorType))
+	unmarshal.Body.List = append(unmarshal.Body.List,
+		newDef(reader, newCall(newSel("bstruct", "NewReader"), &ast.CallExpr{
+			Fun:      newIdent("append"),
+			Args:     []ast.Expr{newCall(&ast.ParenExpr{X: byteSlice}, newIdent("nil")), data},
+			Ellipsis: token.Pos(1),
+		})),
+		newCallST(newSel("v", "Decode"), reader),
+		newReturn(newCall(newSel(reader, "Err"))),
+	)
+
+	return append(decls, marshal, app, unmarshal)
+}
+
+// streamDecl implements io.WriterTo and io.ReaderFrom, one frame per value.
+func (e *Builder) streamDecl(el *Field) (decls []ast.Decl) {
+	w := ast.NewIdent("w")
+	writer := ast.NewIdent("wt")
+	writeTo, _ := e.getFunc(el, "WriteTo")
+	writeTo.Type.Params.List = append(writeTo.Type.Params.List, newParam(w.Name, newSel("io", "Writer")))
+	writeTo.Type.Results.List = append(writeTo.Type.Results.List, newParam("", newIdent("int64")), newParam("", errorType))
+	writeTo.Body.List = append(writeTo.Body.List,
+		newDef(writer, newCall(newSel("bstruct", "NewWriter"))),
+		newCallST(newSel("v", "Encode"), writer),
+		newReturn(newCall(newSel("bstruct", "WriteFrame"), w, newCall(newSel(writer, "Data")))),
+	)
+
+	r := ast.NewIdent("r")
+	data := ast.NewIdent("data")
+	n := ast.NewIdent("n")
+	err := ast.NewIdent("err")
+	reader := ast.NewIdent("rd")
+	readFrom, _ := e.getFunc(el, "ReadFrom")
+	readFrom.Type.Params.List = append(readFrom.Type.Params.List, newParam(r.Name, newSel("io", "Reader")))
+	readFrom.Type.Results.List = append(readFrom.Type.Results.List, newParam("", newIdent("int64")), newParam("", errorType))
+	readFrom.Body.List = append(readFrom.Body.List,
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{data, n, err},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{newCall(newSel("bstruct", "ReadFrame"), r)},
+		},
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: err, Op: token.NEQ, Y: newIdent("nil")},
+			Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(n, err)}},
+		},
+		newDef(reader, newCall(newSel("bstruct", "NewReader"), data)),
+		newCallST(newSel("v", "Decode"), reader),
+		newReturn(n, newCall(newSel(reader, "Err"))),
+	)
+
+	return append(decls, writeTo, readFrom)
+}
+
+// registryDecl identifies the type by a TypeID method and registers it.
+func (e *Builder) registryDecl(el *Field) (decls []ast.Decl) {
+	id := ast.NewIdent(fmt.Sprintf("%sTypeID", el.typename))
+	decls = append(decls, &ast.GenDecl{
+		Tok: token.CONST,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{id},
+				Type:   newIdent("uint64"),
+				Values: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("%#x", el.messageID())}},
+			},
+		},
+	})
+
+	getter, _ := e.getFunc(el, "TypeID")
+	getter.Type.Results.List = append(getter.Type.Results.List, newParam("", newIdent("uint64")))
+	getter.Body.List = append(getter.Body.List, newReturn(id))
+
+	ctor := &ast.FuncLit{
+		Type: &ast.FuncType{
+			Params:  &ast.FieldList{},
+			Results: &ast.FieldList{List: []*ast.Field{newParam("", newSel("bstruct", "Message"))}},
+		},
+		Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(newCall(newIdent("new"), newIdent(el.typename)))}},
+	}
+	init := &ast.FuncDecl{
+		Name: ast.NewIdent("init"),
+		Type: &ast.FuncType{Params: &ast.FieldList{}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{newCallST(newSel("bstruct", "Register"), ctor)}},
+	}
+
+	return append(decls, getter, init)
+}
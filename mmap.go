@@ -0,0 +1,82 @@
+package bstruct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrMappingClosed = errors.New("bstruct: mapping closed")
+
+// Mapped is a file mapped into memory. Strings and primitive slices decoded
+// from it alias the mapping, and must not be used after Close: the memory is
+// unmapped and touching it crashes the program. So does truncating the file
+// while it is mapped, which raises SIGBUS on the pages past its new end.
+type Mapped struct {
+	data   []byte
+	closed bool
+}
+
+// OpenMapped maps the file at path read-only. On platforms without mmap
+// support, the file is read into memory instead.
+func OpenMapped(path string) (*Mapped, error) {
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapped{data: data}, nil
+}
+
+func (m *Mapped) Bytes() []byte {
+	return m.data
+}
+
+// Reader returns a Reader over the whole file.
+func (m *Mapped) Reader() *Reader {
+	return NewReader(m.data)
+}
+
+func (m *Mapped) Close() error {
+	data := m.data
+	m.data = nil
+	m.closed = true
+	return unmapFile(data)
+}
+
+// MappedRecords decodes the records of a mapped record file on demand,
+// without copying them. It is invalid after Close of its Mapped, and Read
+// fails with ErrMappingClosed.
+type MappedRecords struct {
+	m       *Mapped
+	offsets []int64
+}
+
+// Records opens the mapped file as a record file of the given schema hash.
+func (m *Mapped) Records(hash uint64) (*MappedRecords, error) {
+	rf, err := OpenRecords(bytes.NewReader(m.data), int64(len(m.data)), hash)
+	if err != nil {
+		return nil, err
+	}
+	return &MappedRecords{m: m, offsets: rf.offsets}, nil
+}
+
+func (mr *MappedRecords) Len() int {
+	return len(mr.offsets)
+}
+
+// Read decodes record i into v, aliasing the mapping.
+func (mr *MappedRecords) Read(i int, v Codec) error {
+	if mr.m.closed {
+		return ErrMappingClosed
+	}
+	if i < 0 || i >= len(mr.offsets) {
+		return fmt.Errorf("bstruct: record %d out of range [0, %d)", i, len(mr.offsets))
+	}
+	data := mr.m.data[mr.offsets[i]:]
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length == 0 || length > uint64(len(data)) || length-1+4 > uint64(len(data)-n) {
+		return ErrNotRecords
+	}
+	return decodeRecord(data[n:n+int(length-1)+4], v)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package bstruct
+
+import "os"
+
+func mapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,389 @@
+package bstruct
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+var ErrNotRecords = errors.New("bstruct: not a record file")
+
+// A record file starts with a header of recordMagic, recordVersion and the
+// little-endian schema hash of its records. Every record is a block of
+//
+//	uvarint length+1, value, little-endian CRC32C of value
+//
+// and a length of 0 ends the records. An index may follow it, of
+//
+//	uvarint count, little-endian uint64 offset of every record, CRC32C
+//
+// and then a footer of the little-endian uint64 offset of the end marker and
+// indexMagic, so that readers find the index from the end of the file.
+const (
+	recordMagic   = "bstr"
+	indexMagic    = "bidx"
+	recordVersion = 1
+	headerSize    = 4 + 1 + 8
+	footerSize    = 8 + 4
+)
+
+// RecordWriter appends records to a record file.
+type RecordWriter struct {
+	w       io.Writer
+	wt      *Writer
+	off     int64
+	index   bool
+	offsets []int64
+}
+
+// NewRecordWriter writes the header of a file of records with the given
+// schema hash, usually the SchemaHash of the generated type.
+func NewRecordWriter(w io.Writer, hash uint64) (*RecordWriter, error) {
+	var hdr [headerSize]byte
+	copy(hdr[:], recordMagic)
+	hdr[len(recordMagic)] = recordVersion
+	binary.LittleEndian.PutUint64(hdr[len(recordMagic)+1:], hash)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &RecordWriter{w: w, wt: NewWriter(), off: int64(headerSize)}, nil
+}
+
+// OpenRecordWriter appends to the record file f of the given schema hash,
+// after its last intact record. The end marker, the index and a torn record
+// following it are cut off, Close writes them again.
+func OpenRecordWriter(f *os.File, hash uint64) (*RecordWriter, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offsets, end, err := openRecords(f, info.Size(), hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &RecordWriter{w: f, wt: NewWriter(), off: end, offsets: offsets}, nil
+}
+
+// Index makes Close write the index of the records.
+func (rw *RecordWriter) Index(f bool) *RecordWriter {
+	rw.index = f
+	return rw
+}
+
+// Append writes v as the next record, with a single Write call.
+func (rw *RecordWriter) Append(v Codec) error {
+	const room = binary.MaxVarintLen64
+	rw.wt.Reset()
+	rw.wt.grow(room)
+	rw.wt.pos = room
+	v.Encode(rw.wt)
+	body := rw.wt.data[room:rw.wt.pos]
+
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.Checksum(body, castagnoli))
+	var hdr [room]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(body))+1)
+	copy(rw.wt.data[room-n:], hdr[:n])
+	rw.wt.Write(crc[:])
+
+	written, err := rw.w.Write(rw.wt.data[room-n : rw.wt.pos])
+	if err != nil {
+		return err
+	}
+	rw.offsets = append(rw.offsets, rw.off)
+	rw.off += int64(written)
+	return nil
+}
+
+// Close ends the records and writes the index, if any. The underlying writer
+// is not closed.
+func (rw *RecordWriter) Close() error {
+	wt := NewWriter()
+	wt.WriteUvarint(0)
+	if rw.index {
+		start := wt.Pos()
+		wt.WriteUvarint(uint64(len(rw.offsets)))
+		for _, off := range rw.offsets {
+			wt.grow(8)
+			binary.LittleEndian.PutUint64(wt.data[wt.pos:], uint64(off))
+			wt.pos += 8
+		}
+		wt.grow(4 + footerSize)
+		binary.LittleEndian.PutUint32(wt.data[wt.pos:], crc32.Checksum(wt.data[start:wt.pos], castagnoli))
+		wt.pos += 4
+		binary.LittleEndian.PutUint64(wt.data[wt.pos:], uint64(rw.off))
+		wt.pos += 8
+		wt.Write([]byte(indexMagic))
+	}
+	_, err := rw.w.Write(wt.Data())
+	return err
+}
+
+func checkHeader(hdr []byte, hash uint64) error {
+	if string(hdr[:len(recordMagic)]) != recordMagic || hdr[len(recordMagic)] != recordVersion {
+		return ErrNotRecords
+	}
+	if got := binary.LittleEndian.Uint64(hdr[len(recordMagic)+1:]); got != hash {
+		return fmt.Errorf("%w: got %#016x, want %#016x", ErrSchemaMismatch, got, hash)
+	}
+	return nil
+}
+
+// RecordReader reads the records of a file in order. The record buffer is
+// reused, so strings and primitive slices of a decoded value are only valid
+// until the next call to Next.
+type RecordReader struct {
+	r   *bufio.Reader
+	buf []byte
+	end bool
+}
+
+func NewRecordReader(r io.Reader, hash uint64) (*RecordReader, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := checkHeader(hdr[:], hash); err != nil {
+		return nil, err
+	}
+	return &RecordReader{r: bufio.NewReader(r)}, nil
+}
+
+// Next decodes the next record into v. It returns io.EOF after the last
+// record, and io.ErrUnexpectedEOF on a torn one, see Recover.
+func (rr *RecordReader) Next(v Codec) error {
+	if rr.end {
+		return io.EOF
+	}
+	length, _, err := readUvarint(rr.r)
+	if err == io.EOF {
+		// a file whose writer was not closed
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		rr.end = true
+		return io.EOF
+	}
+	length--
+	if length > uint64(DefaultMaxFrame) {
+		return ErrFrameTooLarge
+	}
+	if uint64(cap(rr.buf)) < length+4 {
+		rr.buf = make([]byte, length+4)
+	}
+	rr.buf = rr.buf[:length+4]
+	if _, err := io.ReadFull(rr.r, rr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return decodeRecord(rr.buf, v)
+}
+
+// decodeRecord checks the checksum trailing data and decodes the rest into v.
+func decodeRecord(data []byte, v Codec) error {
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return ErrChecksum
+	}
+	rd := NewReader(body)
+	v.Decode(rd)
+	return rd.Err()
+}
+
+// RecordFile reads records by their position. The offsets of the records
+// come from the index, or are collected by a scan of the file without one.
+type RecordFile struct {
+	r       io.ReaderAt
+	offsets []int64
+}
+
+// OpenRecords opens the record file r of the given size.
+func OpenRecords(r io.ReaderAt, size int64, hash uint64) (*RecordFile, error) {
+	offsets, _, err := openRecords(r, size, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordFile{r: r, offsets: offsets}, nil
+}
+
+// openRecords checks the header of r, and returns the offsets of its records
+// and the end of the last one.
+func openRecords(r io.ReaderAt, size int64, hash uint64) ([]int64, int64, error) {
+	var hdr [headerSize]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if err := checkHeader(hdr[:], hash); err != nil {
+		return nil, 0, err
+	}
+	offsets, end, err := readIndex(r, size)
+	if err != nil || offsets != nil {
+		return offsets, end, err
+	}
+	return scanRecords(r, size)
+}
+
+// readIndex returns the offsets stored in the index and the end of the
+// records, or nil without an index.
+func readIndex(r io.ReaderAt, size int64) ([]int64, int64, error) {
+	var footer [footerSize]byte
+	if size < int64(headerSize+footerSize) {
+		return nil, 0, nil
+	}
+	if _, err := r.ReadAt(footer[:], size-footerSize); err != nil {
+		return nil, 0, err
+	}
+	if string(footer[8:]) != indexMagic {
+		return nil, 0, nil
+	}
+	end := int64(binary.LittleEndian.Uint64(footer[:]))
+	if end < int64(headerSize) || end >= size-footerSize {
+		return nil, 0, ErrNotRecords
+	}
+	data := make([]byte, size-footerSize-end)
+	if _, err := r.ReadAt(data, end); err != nil {
+		return nil, 0, err
+	}
+	// the end marker, then the index
+	if len(data) < 5 || data[0] != 0 {
+		return nil, 0, ErrNotRecords
+	}
+	data = data[1:]
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, 0, ErrChecksum
+	}
+	count, n := binary.Uvarint(body)
+	if n <= 0 || (len(body)-n)%8 != 0 || uint64((len(body)-n)/8) != count {
+		return nil, 0, ErrNotRecords
+	}
+	// offsets are trusted by readers of the records, so they have to be
+	// increasing and within the records
+	offsets := make([]int64, count)
+	prev := int64(headerSize) - 1
+	for i := range offsets {
+		off := int64(binary.LittleEndian.Uint64(body[n+i*8:]))
+		if off <= prev || off >= end {
+			return nil, 0, ErrNotRecords
+		}
+		offsets[i], prev = off, off
+	}
+	return offsets, end, nil
+}
+
+// scanRecords walks the records of r, returning their offsets and the end of
+// the last intact one. Scanning stops silently at a torn tail: a record
+// running past the end of r, or a bad checksum on the last one. A bad
+// checksum followed by more data is ErrChecksum.
+func scanRecords(r io.ReaderAt, size int64) ([]int64, int64, error) {
+	offsets := []int64{}
+	off := int64(headerSize)
+	for off < size {
+		var hdr [binary.MaxVarintLen64]byte
+		n, err := r.ReadAt(hdr[:], off)
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		length, m := binary.Uvarint(hdr[:n])
+		if m <= 0 {
+			break
+		}
+		if length == 0 {
+			return offsets, off, nil
+		}
+		if length-1 > uint64(size) {
+			break
+		}
+		next := off + int64(m) + int64(length-1) + 4
+		if next > size {
+			break
+		}
+		data := make([]byte, length-1+4)
+		if _, err := r.ReadAt(data, off+int64(m)); err != nil {
+			return nil, 0, err
+		}
+		body := data[:len(data)-4]
+		if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(data[len(body):]) {
+			if next < size {
+				return nil, 0, ErrChecksum
+			}
+			break
+		}
+		offsets = append(offsets, off)
+		off = next
+	}
+	return offsets, off, nil
+}
+
+func (rf *RecordFile) Len() int {
+	return len(rf.offsets)
+}
+
+// Read decodes record i into v. Strings and primitive slices of v are backed
+// by a buffer of their own.
+func (rf *RecordFile) Read(i int, v Codec) error {
+	if i < 0 || i >= len(rf.offsets) {
+		return fmt.Errorf("bstruct: record %d out of range [0, %d)", i, len(rf.offsets))
+	}
+	var hdr [binary.MaxVarintLen64]byte
+	n, err := rf.r.ReadAt(hdr[:], rf.offsets[i])
+	if err != nil && err != io.EOF {
+		return err
+	}
+	length, m := binary.Uvarint(hdr[:n])
+	if m <= 0 || length == 0 || length-1 > uint64(DefaultMaxFrame) {
+		return ErrNotRecords
+	}
+	data := make([]byte, length-1+4)
+	if _, err := rf.r.ReadAt(data, rf.offsets[i]+int64(m)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return decodeRecord(data, v)
+}
+
+// Recover truncates f after its last intact record when it was not closed
+// properly, e.g. on a crash during Append, and returns the number of intact
+// records. Closed files are left as they are, and so are files corrupted
+// before their tail, which fail with ErrChecksum.
+func Recover(f *os.File, hash uint64) (int, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offsets, end, err := openRecords(f, info.Size(), hash)
+	if err != nil {
+		return 0, err
+	}
+	if index, _, _ := readIndex(f, info.Size()); index != nil {
+		return len(offsets), nil
+	}
+	var marker [1]byte
+	if n, _ := f.ReadAt(marker[:], end); n == 1 && marker[0] == 0 && end+1 == info.Size() {
+		return len(offsets), nil
+	}
+	return len(offsets), f.Truncate(end)
+}
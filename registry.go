@@ -0,0 +1,61 @@
+package bstruct
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownType = errors.New("bstruct: unknown type id")
+
+// Message is a Codec identifying its type, as generated by Builder.Registry.
+type Message interface {
+	Codec
+	TypeID() uint64
+}
+
+var registry sync.Map
+
+// Register makes the type of the Messages returned by fn decodable by
+// DecodeAny. It panics if the type id is taken by another type.
+func Register(fn func() Message) {
+	id := fn().TypeID()
+	if prev, ok := registry.LoadOrStore(id, fn); ok {
+		if a, b := fmt.Sprintf("%T", prev.(func() Message)()), fmt.Sprintf("%T", fn()); a != b {
+			panic(fmt.Sprintf("type id %#x of %s is taken by %s", id, b, a))
+		}
+	}
+}
+
+// NewMessage returns a new Message of the type registered as id.
+func NewMessage(id uint64) (Message, bool) {
+	fn, ok := registry.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return fn.(func() Message)(), true
+}
+
+// EncodeAny writes the type id of v ahead of its encoding.
+func EncodeAny(w *Writer, v Message) {
+	w.WriteUvarint(v.TypeID())
+	v.Encode(w)
+}
+
+// DecodeAny decodes a value written by EncodeAny into a new Message of the
+// registered type.
+func DecodeAny(r *Reader) (Codec, error) {
+	id := r.ReadUvarint()
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	v, ok := NewMessage(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %#x", ErrUnknownType, id)
+	}
+	v.Decode(r)
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return v, nil
+}
@@ -0,0 +1,19 @@
+package bstruct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageID(t *testing.T) {
+	e := NewBuilder()
+	ping := New(FieldStruct).Reg(e, "Ping").Add("A", "", false, New(FieldBool))
+	pong := New(FieldStruct).Reg(e, "Pong").Add("A", "", false, New(FieldBool))
+	require.Equal(t, ping.SchemaHash(), pong.SchemaHash())
+	require.NotEqual(t, ping.messageID(), pong.messageID())
+	id := ping.messageID()
+	ping.Add("B", "", false, New(FieldBool))
+	require.Equal(t, id, ping.messageID())
+	require.Equal(t, uint64(9), pong.TypeID(9).messageID())
+}
@@ -0,0 +1,340 @@
+package bstruct
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("bstruct: connection closed")
+
+// ServerError is an error returned by the handler of a remote call.
+type ServerError struct {
+	Method  string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("bstruct: %s: %s", e.Method, e.Message)
+}
+
+// Frames of a connection carry a kind, the call id and then
+//
+//	rpcCall:   method, deadline in unix nanoseconds or 0, request
+//	rpcCancel: nothing
+//	rpcReply:  error message or "", response
+const (
+	rpcCall = iota
+	rpcCancel
+	rpcReply
+)
+
+type rpcConn struct {
+	fr *FrameReader
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func newRPCConn(conn io.ReadWriter, max int) *rpcConn {
+	return &rpcConn{fr: NewFrameReader(conn).MaxSize(max), w: conn}
+}
+
+func (c *rpcConn) send(wt *Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := WriteFrame(c.w, wt.Data())
+	return err
+}
+
+// recv returns the next frame past its kind and call id. The frame is
+// copied, as it is decoded while later frames are read.
+func (c *rpcConn) recv() (*Reader, int, uint64, error) {
+	data, err := c.fr.next()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	rd := NewReader(append([]byte(nil), data...))
+	kind := rd.ReadUvarint()
+	id := rd.ReadUvarint()
+	return rd, int(kind), id, rd.Err()
+}
+
+func readString(rd *Reader) string {
+	length := rd.ReadLen()
+	start := rd.Pos()
+	rd.Skip(length)
+	if rd.Err() != nil {
+		return ""
+	}
+	return string(rd.Data()[start:rd.Pos()])
+}
+
+func writeString(wt *Writer, s string) {
+	wt.WriteLen(len(s))
+	wt.Write([]byte(s))
+}
+
+type handler struct {
+	req func() Codec
+	fn  func(context.Context, Codec) (Codec, error)
+}
+
+// Server dispatches the calls of Clients to the handlers of their methods.
+type Server struct {
+	handlers map[string]handler
+	max      int
+}
+
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]handler), max: DefaultMaxFrame}
+}
+
+// MaxSize limits the frames read from clients, connections sending larger
+// ones fail with ErrFrameTooLarge.
+func (s *Server) MaxSize(n int) *Server {
+	s.max = n
+	return s
+}
+
+// Handle serves method by fn, on a request created by req. The context of fn
+// carries the deadline of the caller, and is cancelled with the call.
+func (s *Server) Handle(method string, req func() Codec, fn func(context.Context, Codec) (Codec, error)) {
+	if _, ok := s.handlers[method]; ok {
+		panic(fmt.Sprintf("method %s is already handled", method))
+	}
+	s.handlers[method] = handler{req: req, fn: fn}
+}
+
+// ServeConn serves calls from conn concurrently until it fails or ends, and
+// closes conn. Calls still running are cancelled.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	defer conn.Close()
+	c := newRPCConn(conn, s.max)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	calls := make(map[uint64]context.CancelFunc)
+	for {
+		rd, kind, id, err := c.recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case rpcCall:
+			method := readString(rd)
+			deadline := rd.ReadUvarint()
+			if rd.Err() != nil {
+				return rd.Err()
+			}
+			callCtx, callCancel := context.WithCancel(ctx)
+			if deadline != 0 {
+				callCtx, callCancel = context.WithDeadline(ctx, time.Unix(0, int64(deadline)))
+			}
+			mu.Lock()
+			calls[id] = callCancel
+			mu.Unlock()
+			go func() {
+				defer func() {
+					mu.Lock()
+					delete(calls, id)
+					mu.Unlock()
+					callCancel()
+				}()
+				s.serve(callCtx, c, id, method, rd)
+			}()
+		case rpcCancel:
+			mu.Lock()
+			if callCancel, ok := calls[id]; ok {
+				callCancel()
+			}
+			mu.Unlock()
+		default:
+			return fmt.Errorf("bstruct: unexpected frame kind %d", kind)
+		}
+	}
+}
+
+func (s *Server) serve(ctx context.Context, c *rpcConn, id uint64, method string, rd *Reader) {
+	body, err := s.call(ctx, method, rd)
+	wt := NewWriter()
+	wt.WriteUvarint(rpcReply)
+	wt.WriteUvarint(id)
+	if err != nil {
+		writeString(wt, err.Error())
+	} else {
+		writeString(wt, "")
+		wt.Write(body)
+	}
+	// a failed connection ends ServeConn already
+	_ = c.send(wt)
+}
+
+// call returns the encoded response of method.
+func (s *Server) call(ctx context.Context, method string, rd *Reader) (body []byte, err error) {
+	h, ok := s.handlers[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+	req := h.req()
+	req.Decode(rd)
+	if rd.Err() != nil {
+		return nil, rd.Err()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	resp, err := h.fn(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	wt := NewWriter()
+	resp.Encode(wt)
+	return wt.Data(), nil
+}
+
+type reply struct {
+	rd  *Reader
+	err error
+}
+
+// Client calls the methods of a Server over a single connection, any number
+// of calls may be in flight at once.
+type Client struct {
+	conn    io.ReadWriteCloser
+	c       *rpcConn
+	max     int
+	start   sync.Once
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan reply
+	err     error
+}
+
+// NewClient calls over conn. Replies are read from the first Call on, until
+// Close.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{
+		conn:    conn,
+		max:     DefaultMaxFrame,
+		pending: make(map[uint64]chan reply),
+	}
+}
+
+// MaxSize limits the frames read from the server, which fail the connection
+// with ErrFrameTooLarge beyond it. It has to be set before the first Call.
+func (cl *Client) MaxSize(n int) *Client {
+	cl.max = n
+	return cl
+}
+
+func (cl *Client) read() {
+	for {
+		rd, kind, id, err := cl.c.recv()
+		if err == nil && kind != rpcReply {
+			err = fmt.Errorf("bstruct: unexpected frame kind %d", kind)
+		}
+		if err != nil {
+			cl.mu.Lock()
+			cl.err = fmt.Errorf("%w: %v", ErrClosed, err)
+			for id, ch := range cl.pending {
+				ch <- reply{err: cl.err}
+				delete(cl.pending, id)
+			}
+			cl.mu.Unlock()
+			return
+		}
+		cl.mu.Lock()
+		ch, ok := cl.pending[id]
+		delete(cl.pending, id)
+		cl.mu.Unlock()
+		if ok {
+			ch <- reply{rd: rd}
+		}
+	}
+}
+
+// Call calls method with req and decodes the reply into resp. Errors of the
+// handler are returned as *ServerError. The deadline of ctx is sent along,
+// and cancelling ctx cancels the call on the server.
+func (cl *Client) Call(ctx context.Context, method string, req, resp Codec) error {
+	cl.start.Do(func() {
+		cl.c = newRPCConn(cl.conn, cl.max)
+		go cl.read()
+	})
+	ch := make(chan reply, 1)
+	cl.mu.Lock()
+	if cl.err != nil {
+		cl.mu.Unlock()
+		return cl.err
+	}
+	cl.next++
+	id := cl.next
+	cl.pending[id] = ch
+	cl.mu.Unlock()
+
+	var deadline uint64
+	if t, ok := ctx.Deadline(); ok {
+		deadline = uint64(t.UnixNano())
+	}
+	wt := NewWriter()
+	wt.WriteUvarint(rpcCall)
+	wt.WriteUvarint(id)
+	writeString(wt, method)
+	wt.WriteUvarint(deadline)
+	req.Encode(wt)
+	if err := cl.c.send(wt); err != nil {
+		cl.forget(id)
+		return err
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		if msg := readString(r.rd); msg != "" {
+			// most likely the server gave up on the same deadline, which
+			// may pass there before the timer of ctx fires here
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if t, ok := ctx.Deadline(); ok && !time.Now().Before(t) {
+				return context.DeadlineExceeded
+			}
+			return &ServerError{Method: method, Message: msg}
+		}
+		resp.Decode(r.rd)
+		return r.rd.Err()
+	case <-ctx.Done():
+		if cl.forget(id) {
+			wt := NewWriter()
+			wt.WriteUvarint(rpcCancel)
+			wt.WriteUvarint(id)
+			_ = cl.c.send(wt)
+		}
+		return ctx.Err()
+	}
+}
+
+// forget drops a pending call, reporting whether it was still pending.
+func (cl *Client) forget(id uint64) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	_, ok := cl.pending[id]
+	delete(cl.pending, id)
+	return ok
+}
+
+// Close closes the connection, failing the calls in flight.
+func (cl *Client) Close() error {
+	return cl.conn.Close()
+}
@@ -0,0 +1,302 @@
+package bstruct
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+)
+
+// Types returns the registered types, sorted by name.
+func (e *Builder) Types() []*Field {
+	var fields []*Field
+	for _, name := range e.names() {
+		fields = append(fields, e.types[name].field)
+	}
+	return fields
+}
+
+// Lookup returns the type registered as name, or nil.
+func (e *Builder) Lookup(name string) *Field {
+	return e.types[name].field
+}
+
+// IsEnvelope reports whether Encode of the generated types writes the schema
+// hash first.
+func (e *Builder) IsEnvelope() bool {
+	return e.envelope
+}
+
+// Name is the registered name, empty for anonymous fields.
+func (s *Field) Name() string {
+	return s.typename
+}
+
+func (s *Field) Kind() FieldType {
+	return s.typ
+}
+
+func (s *Field) Doc() string {
+	return s.comment
+}
+
+// Elem is the element of a slice or string.
+func (s *Field) Elem() *Field {
+	return s.sliceType
+}
+
+func (s *Field) Fields() []StructField {
+	return append([]StructField(nil), s.strucFields...)
+}
+
+func (s *Field) IsEvolvable() bool {
+	return s.evolvable
+}
+
+func (s *Field) KeepsUnknown() bool {
+	return s.unknown
+}
+
+func (s *Field) Reserved() []uint {
+	return append([]uint(nil), s.reserved...)
+}
+
+func (s *Field) CustomType() ast.Expr {
+	return s.custyp
+}
+
+func (f StructField) Name() string {
+	return f.strucName
+}
+
+func (f StructField) Doc() string {
+	return f.comment
+}
+
+func (f StructField) Optional() bool {
+	return f.optional
+}
+
+func (f StructField) ID() uint {
+	return f.id
+}
+
+type builderDesc struct {
+	Getter   bool           `json:"getter,omitempty"`
+	Setter   bool           `json:"setter,omitempty"`
+	Envelope bool           `json:"envelope,omitempty"`
+	Binary   bool           `json:"binary,omitempty"`
+	Stream   bool           `json:"stream,omitempty"`
+	Registry bool           `json:"registry,omitempty"`
+	View     bool           `json:"view,omitempty"`
+	Masks    bool           `json:"masks,omitempty"`
+	Skip     bool           `json:"skip,omitempty"`
+	LineWrap int            `json:"lineWrap,omitempty"`
+	Types    []*fieldDesc   `json:"types"`
+	Services []*serviceDesc `json:"services,omitempty"`
+}
+
+type serviceDesc struct {
+	Name    string        `json:"name"`
+	Doc     string        `json:"doc,omitempty"`
+	Methods []*methodDesc `json:"methods"`
+}
+
+type methodDesc struct {
+	Name     string `json:"name"`
+	Doc      string `json:"doc,omitempty"`
+	Request  string `json:"request"`
+	Response string `json:"response"`
+}
+
+type fieldDesc struct {
+	Kind        string             `json:"kind,omitempty"`
+	Name        string             `json:"name,omitempty"`
+	Ref         string             `json:"ref,omitempty"`
+	Doc         string             `json:"doc,omitempty"`
+	Virtual     bool               `json:"virtual,omitempty"`
+	TypeID      uint64             `json:"typeID,omitempty"`
+	Elem        *fieldDesc         `json:"elem,omitempty"`
+	Fields      []*structFieldDesc `json:"fields,omitempty"`
+	Evolvable   bool               `json:"evolvable,omitempty"`
+	KeepUnknown bool               `json:"keepUnknown,omitempty"`
+	Reserved    []uint             `json:"reserved,omitempty"`
+	Custom      string             `json:"custom,omitempty"`
+}
+
+type structFieldDesc struct {
+	Name     string     `json:"name"`
+	ID       uint       `json:"id"`
+	Doc      string     `json:"doc,omitempty"`
+	Optional bool       `json:"optional,omitempty"`
+	Type     *fieldDesc `json:"type"`
+}
+
+// MarshalJSON describes the options and registered types of e. Registered
+// types are referred to by name, coders of custom fields are not kept.
+func (e *Builder) MarshalJSON() ([]byte, error) {
+	desc := builderDesc{
+		Getter:   e.getter,
+		Setter:   e.setter,
+		Envelope: e.envelope,
+		Binary:   e.binary,
+		Stream:   e.stream,
+		Registry: e.registry,
+		View:     e.view,
+		Masks:    e.masks,
+		Skip:     e.skip,
+		LineWrap: e.lineWrap,
+	}
+	for _, f := range e.Types() {
+		desc.Types = append(desc.Types, e.describe(f, true))
+	}
+	for _, name := range e.serviceNames() {
+		s := e.services[name]
+		sd := &serviceDesc{Name: s.name, Doc: s.comment}
+		for _, m := range s.methods {
+			sd.Methods = append(sd.Methods, &methodDesc{
+				Name:     m.name,
+				Doc:      m.comment,
+				Request:  m.req.typename,
+				Response: m.resp.typename,
+			})
+		}
+		desc.Services = append(desc.Services, sd)
+	}
+	return json.Marshal(desc)
+}
+
+func (e *Builder) describe(s *Field, top bool) *fieldDesc {
+	if _, ok := e.types[s.typename]; ok && !top {
+		return &fieldDesc{Ref: s.typename}
+	}
+
+	desc := &fieldDesc{
+		Kind:        s.typ.String(),
+		Name:        s.typename,
+		Doc:         s.comment,
+		Virtual:     s.virtual,
+		TypeID:      s.typeID,
+		Evolvable:   s.evolvable,
+		KeepUnknown: s.unknown,
+		Reserved:    s.reserved,
+	}
+	switch {
+	case s.typ.IsType(FieldSlice):
+		desc.Elem = e.describe(s.sliceType, false)
+	case s.typ.IsType(FieldStruct):
+		for _, field := range s.strucFields {
+			desc.Fields = append(desc.Fields, &structFieldDesc{
+				Name:     field.strucName,
+				ID:       field.id,
+				Doc:      field.comment,
+				Optional: field.optional,
+				Type:     e.describe(field.Field, false),
+			})
+		}
+	case s.typ.IsType(FieldCustom):
+		desc.Custom = types.ExprString(s.custyp)
+	}
+	return desc
+}
+
+// UnmarshalJSON replaces the options and types of e by the described ones.
+func (e *Builder) UnmarshalJSON(data []byte) error {
+	var desc builderDesc
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return err
+	}
+
+	*e = *NewBuilder()
+	e.Getter(desc.Getter).Setter(desc.Setter).Envelope(desc.Envelope).Binary(desc.Binary).Stream(desc.Stream).Registry(desc.Registry).View(desc.View).Masks(desc.Masks).Skip(desc.Skip).SetLineWrap(desc.LineWrap)
+	for _, t := range desc.Types {
+		if t.Name == "" {
+			return fmt.Errorf("bstruct: type without name")
+		}
+		e.types[t.Name] = builtField{field: &Field{typename: t.Name}}
+	}
+	for _, t := range desc.Types {
+		f := e.types[t.Name].field
+		if err := e.build(f, t); err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+	}
+	for _, sd := range desc.Services {
+		s := NewService(sd.Name).Comment(sd.Doc)
+		for _, m := range sd.Methods {
+			req, resp := e.types[m.Request].field, e.types[m.Response].field
+			if req == nil || resp == nil {
+				return fmt.Errorf("%s.%s: bstruct: unknown type", sd.Name, m.Name)
+			}
+			s.Method(m.Name, m.Doc, req, resp)
+		}
+		s.Reg(e)
+	}
+	return nil
+}
+
+func (e *Builder) resolve(desc *fieldDesc) (*Field, error) {
+	if desc == nil {
+		return nil, fmt.Errorf("bstruct: missing type")
+	}
+	if desc.Ref != "" {
+		el, ok := e.types[desc.Ref]
+		if !ok {
+			return nil, fmt.Errorf("bstruct: unknown type %s", desc.Ref)
+		}
+		return el.field, nil
+	}
+	f := &Field{}
+	return f, e.build(f, desc)
+}
+
+func (e *Builder) build(f *Field, desc *fieldDesc) (err error) {
+	f.typ = parseFieldType(desc.Kind)
+	f.comment = desc.Doc
+	f.virtual = desc.Virtual
+	f.typeID = desc.TypeID
+	switch {
+	case f.typ.IsPrimitive():
+	case f.typ.IsType(FieldString):
+		f.sliceType = New(FieldUint8)
+	case f.typ.IsType(FieldSlice):
+		if f.sliceType, err = e.resolve(desc.Elem); err != nil {
+			return err
+		}
+	case f.typ.IsType(FieldStruct):
+		f.evolvable = desc.Evolvable
+		f.unknown = desc.KeepUnknown
+		f.reserved = desc.Reserved
+		for _, field := range desc.Fields {
+			// the checks of AddID, as errors
+			if field.ID == 0 {
+				return fmt.Errorf("%s: bstruct: field id can not be 0", field.Name)
+			}
+			if f.isReserved(field.ID) {
+				return fmt.Errorf("%s: bstruct: field id %d is reserved", field.Name, field.ID)
+			}
+			if prev, ok := f.fieldByID(field.ID); ok {
+				return fmt.Errorf("%s: bstruct: field id %d is used by %s", field.Name, field.ID, prev.strucName)
+			}
+			el, err := e.resolve(field.Type)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field.Name, err)
+			}
+			f.strucFields = append(f.strucFields, StructField{
+				Field:     el,
+				strucName: field.Name,
+				comment:   field.Doc,
+				optional:  field.Optional,
+				id:        field.ID,
+			})
+		}
+	case f.typ.IsType(FieldCustom):
+		if f.custyp, err = parser.ParseExpr(desc.Custom); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("bstruct: unknown kind %q", desc.Kind)
+	}
+	return nil
+}
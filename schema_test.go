@@ -0,0 +1,69 @@
+package bstruct
+
+import (
+	"encoding/json"
+	"go/ast"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescriptor(t *testing.T) {
+	enc := NewBuilder().Getter(true).Envelope(true).SetLineWrap(40)
+	struc := New(FieldStruct).
+		Reg(enc, "Struct1").
+		Comment("Struct1 doc").
+		Add("A", "a", false, New(FieldBool)).
+		Add("B", "", true, NewSlice(NewString())).
+		Add("C", "", false, NewCustom(ast.NewIdent("time.Duration"), nil, nil))
+	struc.Add("D", "", false, NewSlice(struc))
+	New(FieldStruct).
+		Reg(enc, "Struct2").
+		KeepUnknown().
+		Add("A", "", false, struc).
+		Reserve(2).
+		AddID(3, "C", "", false, New(FieldFloat64))
+
+	data, err := json.Marshal(enc)
+	require.NoError(t, err)
+	dec := NewBuilder()
+	require.NoError(t, json.Unmarshal(data, dec))
+	again, err := json.Marshal(dec)
+	require.NoError(t, err)
+	require.JSONEq(t, string(data), string(again))
+	require.Empty(t, Compare(enc, dec))
+
+	types := dec.Types()
+	require.Len(t, types, 2)
+	require.Equal(t, "Struct1", types[0].Name())
+	require.Equal(t, "Struct1 doc", types[0].Doc())
+	require.Equal(t, FieldStruct, types[0].Kind())
+	fields := types[0].Fields()
+	require.Equal(t, "B", fields[1].Name())
+	require.True(t, fields[1].Optional())
+	require.Equal(t, FieldString, fields[1].Elem().Kind())
+	require.Same(t, types[0], fields[3].Elem())
+	require.Equal(t, []uint{2}, types[1].Reserved())
+	require.Equal(t, struc.SchemaHash(), types[0].SchemaHash())
+
+	enc.Process()
+	dec.Process()
+	a, b := new(strings.Builder), new(strings.Builder)
+	require.NoError(t, enc.Print(a, "main"))
+	require.NoError(t, dec.Print(b, "main"))
+	require.Equal(t, a.String(), b.String())
+}
+
+func TestDescriptorIDs(t *testing.T) {
+	for _, fields := range []string{
+		`{"name":"A","id":0,"type":{"kind":"bool"}}`,
+		`{"name":"A","id":2,"type":{"kind":"bool"}}`,
+		`{"name":"A","id":1,"type":{"kind":"bool"}},{"name":"B","id":1,"type":{"kind":"bool"}}`,
+	} {
+		data := `{"types":[{"name":"Msg","kind":"struct","reserved":[2],"fields":[` + fields + `]}]}`
+		require.Error(t, json.Unmarshal([]byte(data), NewBuilder()), fields)
+	}
+	data := `{"types":[{"name":"Msg","kind":"struct","reserved":[2],"fields":[{"name":"A","id":3,"type":{"kind":"bool"}}]}]}`
+	require.NoError(t, json.Unmarshal([]byte(data), NewBuilder()))
+}
@@ -0,0 +1,101 @@
+package bstruct
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownKey = errors.New("bstruct: unknown key id")
+	ErrDecrypt    = errors.New("bstruct: message authentication failed")
+)
+
+// Keyring holds the AES-GCM keys of sealed payloads by id. New payloads are
+// sealed by the primary key, the first one added unless set by Primary, so
+// that keys can be rotated while older payloads stay readable.
+type Keyring struct {
+	keys    map[uint32]cipher.AEAD
+	primary uint32
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32]cipher.AEAD)}
+}
+
+// Add adds an AES-128, AES-192 or AES-256 key.
+func (k *Keyring) Add(id uint32, key []byte) error {
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("bstruct: key id %d is in use", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	if len(k.keys) == 0 {
+		k.primary = id
+	}
+	k.keys[id] = aead
+	return nil
+}
+
+func (k *Keyring) Primary(id uint32) error {
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+	k.primary = id
+	return nil
+}
+
+// A sealed payload is the little-endian key id, a random nonce and the
+// ciphertext, the key id being authenticated as well.
+const keyIDSize = 4
+
+// Seal replaces the content of w by its sealed form, to be opened by
+// NewSealedReader. Nonces are random, which is safe for up to about 2^32
+// payloads per key.
+func (w *Writer) Seal(k *Keyring) error {
+	aead, ok := k.keys[k.primary]
+	if !ok {
+		return ErrUnknownKey
+	}
+	out := make([]byte, keyIDSize+aead.NonceSize(), keyIDSize+aead.NonceSize()+w.pos+aead.Overhead())
+	binary.LittleEndian.PutUint32(out, k.primary)
+	nonce := out[keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out = aead.Seal(out, nonce, w.Data(), out[:keyIDSize])
+	w.Reset()
+	w.Write(out)
+	return nil
+}
+
+// NewSealedReader authenticates and decrypts data, as written by Seal, and
+// returns a Reader of the plaintext.
+func NewSealedReader(data []byte, k *Keyring) (*Reader, error) {
+	if len(data) < keyIDSize {
+		return nil, ErrShortData
+	}
+	id := binary.LittleEndian.Uint32(data)
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+	if len(data) < keyIDSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrShortData
+	}
+	nonce := data[keyIDSize : keyIDSize+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[keyIDSize+aead.NonceSize():], data[:keyIDSize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return NewReader(plain), nil
+}
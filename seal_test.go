@@ -0,0 +1,54 @@
+package bstruct
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeal(t *testing.T) {
+	k := NewKeyring()
+	require.NoError(t, k.Add(1, bytes.Repeat([]byte{1}, 16)))
+	require.NoError(t, k.Add(2, bytes.Repeat([]byte{2}, 32)))
+	require.Error(t, k.Add(2, bytes.Repeat([]byte{2}, 32)))
+	require.Error(t, k.Add(3, []byte("short")))
+
+	wt := NewWriter()
+	wt.WriteLen(6)
+	wt.Write([]byte("secret"))
+	plain := append([]byte(nil), wt.Data()...)
+	require.NoError(t, wt.Seal(k))
+	sealed := append([]byte(nil), wt.Data()...)
+	require.NotContains(t, string(sealed), "secret")
+	require.Equal(t, []byte{1, 0, 0, 0}, sealed[:4])
+
+	rd, err := NewSealedReader(sealed, k)
+	require.NoError(t, err)
+	require.Equal(t, plain, rd.Data())
+
+	// rotated keys still open older payloads
+	require.NoError(t, k.Primary(2))
+	wt.Reset()
+	wt.Write(plain)
+	require.NoError(t, wt.Seal(k))
+	require.Equal(t, []byte{2, 0, 0, 0}, wt.Data()[:4])
+	rd, err = NewSealedReader(wt.Data(), k)
+	require.NoError(t, err)
+	require.Equal(t, plain, rd.Data())
+	rd, err = NewSealedReader(sealed, k)
+	require.NoError(t, err)
+	require.Equal(t, plain, rd.Data())
+
+	for i := range sealed {
+		sealed[i] ^= 1
+		_, err = NewSealedReader(sealed, k)
+		require.Error(t, err)
+		sealed[i] ^= 1
+	}
+	_, err = NewSealedReader(sealed, NewKeyring())
+	require.ErrorIs(t, err, ErrUnknownKey)
+	_, err = NewSealedReader(sealed[:10], k)
+	require.ErrorIs(t, err, ErrShortData)
+	require.ErrorIs(t, k.Primary(9), ErrUnknownKey)
+}
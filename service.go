@@ -0,0 +1,201 @@
+package bstruct
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// Service is a set of RPC methods, generated as a XServer interface with its
+// RegisterXServer and a XClient calling it through a Client.
+type Service struct {
+	name    string
+	comment string
+	methods []Method
+}
+
+// Method takes a request and returns a response, both registered structs.
+type Method struct {
+	name    string
+	comment string
+	req     *Field
+	resp    *Field
+}
+
+func NewService(name string) *Service {
+	return &Service{name: name}
+}
+
+func (s *Service) Comment(comment string) *Service {
+	s.comment = comment
+	return s
+}
+
+func (s *Service) Method(name, comment string, req, resp *Field) *Service {
+	for _, m := range s.methods {
+		if m.name == name {
+			panic(fmt.Sprintf("method %s is defined twice", name))
+		}
+	}
+	if req.typename == "" || resp.typename == "" {
+		panic(fmt.Sprintf("method %s: request and response must be registered", name))
+	}
+	s.methods = append(s.methods, Method{name: name, comment: comment, req: req, resp: resp})
+	return s
+}
+
+func (s *Service) Reg(e *Builder) *Service {
+	e.services[s.name] = s
+	return s
+}
+
+func (s *Service) Name() string {
+	return s.name
+}
+
+func (s *Service) Methods() []Method {
+	return append([]Method(nil), s.methods...)
+}
+
+func (m Method) Name() string {
+	return m.name
+}
+
+func (m Method) Request() *Field {
+	return m.req
+}
+
+func (m Method) Response() *Field {
+	return m.resp
+}
+
+func (e *Builder) serviceNames() []string {
+	names := make([]string, 0, len(e.services))
+	for name := range e.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	ctxType   = newSel("context", "Context")
+	codecType = newSel("bstruct", "Codec")
+)
+
+func ptrTo(name string) ast.Expr {
+	return &ast.StarExpr{X: ast.NewIdent(name)}
+}
+
+func funcType(params, results []*ast.Field) *ast.FuncType {
+	return &ast.FuncType{Params: &ast.FieldList{List: params}, Results: &ast.FieldList{List: results}}
+}
+
+func (e *Builder) serviceDecl(s *Service) (decls []ast.Decl) {
+	server := fmt.Sprintf("%sServer", s.name)
+	client := fmt.Sprintf("%sClient", s.name)
+
+	var methods []*ast.Field
+	for _, m := range s.methods {
+		methods = append(methods, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(m.name)},
+			Type: funcType(
+				[]*ast.Field{newParam("ctx", ctxType), newParam("req", ptrTo(m.req.typename))},
+				[]*ast.Field{newParam("", ptrTo(m.resp.typename)), newParam("", errorType)},
+			),
+			Comment: e.commentGroup(m.comment),
+		})
+	}
+	decls = append(decls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Doc: e.commentGroup(s.comment),
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent(server),
+				Type: &ast.InterfaceType{Methods: &ast.FieldList{List: methods}},
+			},
+		},
+	})
+
+	srv := ast.NewIdent("s")
+	impl := ast.NewIdent("impl")
+	register := &ast.FuncDecl{
+		Name: ast.NewIdent(fmt.Sprintf("Register%s", server)),
+		Type: funcType([]*ast.Field{
+			newParam(srv.Name, &ast.StarExpr{X: newSel("bstruct", "Server")}),
+			newParam(impl.Name, ast.NewIdent(server)),
+		}, nil),
+		Body: &ast.BlockStmt{},
+	}
+	for _, m := range s.methods {
+		newReq := &ast.FuncLit{
+			Type: funcType(nil, []*ast.Field{newParam("", codecType)}),
+			Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(newCall("new", ast.NewIdent(m.req.typename)))}},
+		}
+		fn := &ast.FuncLit{
+			Type: funcType(
+				[]*ast.Field{newParam("ctx", ctxType), newParam("req", codecType)},
+				[]*ast.Field{newParam("", codecType), newParam("", errorType)},
+			),
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				newReturn(newCall(newSel(impl, m.name), ast.NewIdent("ctx"), &ast.TypeAssertExpr{X: ast.NewIdent("req"), Type: ptrTo(m.req.typename)})),
+			}},
+		}
+		register.Body.List = append(register.Body.List, newCallST(newSel(srv, "Handle"), methodName(s, m), newReq, fn))
+	}
+	decls = append(decls, register)
+
+	decls = append(decls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent(client),
+				Type: &ast.StructType{Fields: &ast.FieldList{List: []*ast.Field{
+					newParam("c", &ast.StarExpr{X: newSel("bstruct", "Client")}),
+				}}},
+			},
+		},
+	})
+
+	c := ast.NewIdent("c")
+	decls = append(decls, &ast.FuncDecl{
+		Name: ast.NewIdent(fmt.Sprintf("New%s", client)),
+		Type: funcType(
+			[]*ast.Field{newParam(c.Name, &ast.StarExpr{X: newSel("bstruct", "Client")})},
+			[]*ast.Field{newParam("", ptrTo(client))},
+		),
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			newReturn(newPtr(&ast.CompositeLit{Type: ast.NewIdent(client), Elts: []ast.Expr{c}})),
+		}},
+	})
+
+	for _, m := range s.methods {
+		resp := ast.NewIdent("resp")
+		err := ast.NewIdent("err")
+		call := &ast.FuncDecl{
+			Doc:  e.commentGroup(m.comment),
+			Recv: &ast.FieldList{List: []*ast.Field{newParam(c.Name, ptrTo(client))}},
+			Name: ast.NewIdent(m.name),
+			Type: funcType(
+				[]*ast.Field{newParam("ctx", ctxType), newParam("req", ptrTo(m.req.typename))},
+				[]*ast.Field{newParam("", ptrTo(m.resp.typename)), newParam("", errorType)},
+			),
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				newDef(resp, newCall("new", ast.NewIdent(m.resp.typename))),
+				&ast.IfStmt{
+					Init: newDef(err, newCall(newSel(newSel(c, "c"), "Call"), ast.NewIdent("ctx"), methodName(s, m), ast.NewIdent("req"), resp)),
+					Cond: &ast.BinaryExpr{X: err, Op: token.NEQ, Y: ast.NewIdent("nil")},
+					Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(ast.NewIdent("nil"), err)}},
+				},
+				newReturn(resp, ast.NewIdent("nil")),
+			}},
+		}
+		decls = append(decls, call)
+	}
+	return
+}
+
+func methodName(s *Service, m Method) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("%q", s.name+"."+m.name)}
+}
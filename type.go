@@ -0,0 +1,237 @@
+package bstruct
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FromType derives the Field tree of t, registering named structs and slices
+// to e. Struct fields understand the following tags:
+//
+//	bstruct:"-"         skip the field
+//	bstruct:"optional"  prefix the field with a presence flag
+//	bstruct:"int32"     encode an int, uint or uintptr, or a slice of them, as
+//	                    the given type instead of int64 or uint64
+//	bstruct:"id=3"      field id, any id makes the struct evolvable
+//	comment:"..."       comment of the generated field
+//
+// A bool field named __X directly before X marks X optional as well, and a
+// []byte named __unknown keeps unknown fields, which is the layout emitted by
+// the generator.
+func FromType(t reflect.Type, e *Builder) *Field {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	f, err := typeField(t, e, make(map[reflect.Type]*Field))
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+type fieldTag struct {
+	skip     bool
+	optional bool
+	id       uint
+	typ      FieldType
+}
+
+func parseTag(sf reflect.StructField) (tag fieldTag, err error) {
+	for _, opt := range strings.Split(sf.Tag.Get("bstruct"), ",") {
+		switch opt {
+		case "":
+		case "-":
+			tag.skip = true
+		case "optional":
+			tag.optional = true
+		default:
+			if strings.HasPrefix(opt, "id=") {
+				id, err := strconv.ParseUint(opt[3:], 10, 0)
+				if err != nil || id == 0 {
+					return tag, fmt.Errorf("bstruct: invalid field id %q", opt)
+				}
+				tag.id = uint(id)
+				continue
+			}
+			tag.typ = parseFieldType(opt)
+			if !tag.typ.IsPrimitive() {
+				return tag, fmt.Errorf("bstruct: unknown tag option %q", opt)
+			}
+		}
+	}
+	return
+}
+
+func parseFieldType(s string) FieldType {
+	for ft := FieldBool; ft <= FieldCustom; ft++ {
+		if ft.String() == s {
+			return ft
+		}
+	}
+	return FieldInvalid
+}
+
+func typeField(t reflect.Type, e *Builder, seen map[reflect.Type]*Field) (*Field, error) {
+	if f, ok := seen[t]; ok {
+		return f, nil
+	}
+
+	var f *Field
+	switch t.Kind() {
+	case reflect.String:
+		return NewString(), nil
+	case reflect.Slice:
+		f = NewSlice(nil)
+		seen[t] = f
+		elem, err := typeField(t.Elem(), e, seen)
+		if err != nil {
+			return nil, err
+		}
+		f.sliceType = elem
+	case reflect.Struct:
+		f = New(FieldStruct)
+		seen[t] = f
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			optional := false
+			if i+1 < t.NumField() && sf.Type.Kind() == reflect.Bool && sf.Name == newOpt(t.Field(i+1).Name) {
+				i++
+				sf = t.Field(i)
+				optional = true
+			}
+			if sf.Name == unknownName && sf.Type == reflect.TypeOf([]byte(nil)) {
+				f.KeepUnknown()
+				continue
+			}
+			tag, err := parseTag(sf)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t, sf.Name, err)
+			}
+			if tag.skip {
+				continue
+			}
+			var el *Field
+			if tag.typ != FieldInvalid {
+				el, err = hintField(sf.Type, tag.typ)
+			} else {
+				el, err = typeField(sf.Type, e, seen)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t, sf.Name, err)
+			}
+			if tag.id != 0 {
+				f.Evolvable()
+				f.AddID(tag.id, sf.Name, sf.Tag.Get("comment"), optional || tag.optional, el)
+			} else {
+				f.Add(sf.Name, sf.Tag.Get("comment"), optional || tag.optional, el)
+			}
+		}
+	case reflect.Int:
+		return New(FieldInt64), nil
+	case reflect.Uint, reflect.Uintptr:
+		return New(FieldUint64), nil
+	default:
+		for ft := FieldBool; ft <= FieldFloat64; ft++ {
+			if ft.kind() == t.Kind() {
+				return New(ft), nil
+			}
+		}
+		return nil, fmt.Errorf("bstruct: unsupported type %s", t)
+	}
+
+	if e != nil && t.Name() != "" {
+		f.Reg(e, t.Name())
+	}
+	return f, nil
+}
+
+func hintField(t reflect.Type, ft FieldType) (*Field, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Uint, reflect.Uintptr:
+		if isInteger(ft) {
+			return New(ft), nil
+		}
+	case reflect.Slice:
+		elem, err := hintField(t.Elem(), ft)
+		if err != nil {
+			return nil, err
+		}
+		return NewSlice(elem), nil
+	default:
+		if ft.kind() == t.Kind() {
+			return New(ft), nil
+		}
+	}
+	return nil, fmt.Errorf("bstruct: can not encode %s as %s", t, ft)
+}
+
+func isInteger(ft FieldType) bool {
+	return ft.IsPrimitive() && ft != FieldBool && ft != FieldFloat32 && ft != FieldFloat64
+}
+
+func (ft FieldType) kind() reflect.Kind {
+	switch ft {
+	case FieldBool:
+		return reflect.Bool
+	case FieldInt8:
+		return reflect.Int8
+	case FieldInt16:
+		return reflect.Int16
+	case FieldInt32:
+		return reflect.Int32
+	case FieldInt64:
+		return reflect.Int64
+	case FieldUint8:
+		return reflect.Uint8
+	case FieldUint16:
+		return reflect.Uint16
+	case FieldUint32:
+		return reflect.Uint32
+	case FieldUint64:
+		return reflect.Uint64
+	case FieldFloat32:
+		return reflect.Float32
+	case FieldFloat64:
+		return reflect.Float64
+	case FieldString:
+		return reflect.String
+	case FieldSlice:
+		return reflect.Slice
+	case FieldStruct:
+		return reflect.Struct
+	default:
+		return reflect.Invalid
+	}
+}
+
+// reflectType is the Go type generated for a primitive ft.
+func (ft FieldType) reflectType() reflect.Type {
+	switch ft {
+	case FieldBool:
+		return reflect.TypeOf(false)
+	case FieldInt8:
+		return reflect.TypeOf(int8(0))
+	case FieldInt16:
+		return reflect.TypeOf(int16(0))
+	case FieldInt32:
+		return reflect.TypeOf(int32(0))
+	case FieldInt64:
+		return reflect.TypeOf(int64(0))
+	case FieldUint8:
+		return reflect.TypeOf(uint8(0))
+	case FieldUint16:
+		return reflect.TypeOf(uint16(0))
+	case FieldUint32:
+		return reflect.TypeOf(uint32(0))
+	case FieldUint64:
+		return reflect.TypeOf(uint64(0))
+	case FieldFloat32:
+		return reflect.TypeOf(float32(0))
+	case FieldFloat64:
+		return reflect.TypeOf(float64(0))
+	default:
+		return nil
+	}
+}
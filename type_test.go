@@ -0,0 +1,54 @@
+package bstruct
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type typeInner struct {
+	A string
+}
+
+type typeList []typeInner
+
+type typeStruct struct {
+	A bool   `comment:"the a"`
+	B int    `bstruct:"int32"`
+	C string `bstruct:"optional"`
+	D typeList
+	E []typeStruct
+	F func() `bstruct:"-"`
+	G int
+	H []uint `bstruct:"uint16"`
+}
+
+func TestFromType(t *testing.T) {
+	enc := NewBuilder()
+	f := FromType(reflect.TypeOf(&typeStruct{}), enc)
+	require.Equal(t, "typeStruct", f.typename)
+	require.Len(t, enc.types, 3)
+	require.Len(t, f.strucFields, 7)
+	require.True(t, f.strucFields[2].optional)
+	require.Equal(t, FieldInt32, f.strucFields[1].typ)
+	require.Equal(t, FieldInt64, f.strucFields[5].typ)
+	require.Equal(t, FieldUint16, f.strucFields[6].sliceType.typ)
+	require.Same(t, f, f.strucFields[4].sliceType)
+
+	enc.Process()
+	buf := new(strings.Builder)
+	require.NoError(t, enc.Print(buf, "main"))
+	require.Contains(t, buf.String(), "__C\tbool")
+	require.Contains(t, buf.String(), "// the a")
+
+	v := &typeStruct{B: -7, D: typeList{{A: "x"}}, E: []typeStruct{{C: "y"}}, G: -1 << 40, H: []uint{1, 65535}}
+	data, err := Marshal(v)
+	require.NoError(t, err)
+	g := &typeStruct{}
+	require.NoError(t, Unmarshal(data, g))
+	require.Equal(t, v, g)
+
+	require.Panics(t, func() { FromType(reflect.TypeOf(struct{ A map[int]int }{}), enc) })
+}
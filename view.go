@@ -0,0 +1,382 @@
+package bstruct
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"unicode"
+)
+
+// View emits a XView for every struct X, reading single fields out of the
+// encoded bytes on demand. The offsets of the fields are found on the first
+// access, by skipping over the values as SkipX does.
+func (e *Builder) View(f bool) *Builder {
+	e.view = f
+	return e
+}
+
+func viewName(name string) string {
+	return fmt.Sprintf("%sView", name)
+}
+
+// Skip emits SkipX for every type X, advancing a Reader past an encoded
+// value. View and Masks emit it regardless.
+func (e *Builder) Skip(f bool) *Builder {
+	e.skip = f
+	return e
+}
+
+// skipName is the function skipping a nested X, which is SkipX unless the
+// exported one checks the schema hash first.
+func (e *Builder) skipName(name string) string {
+	if e.envelope {
+		return fmt.Sprintf("skip%s", name)
+	}
+	return fmt.Sprintf("Skip%s", name)
+}
+
+// skipDecl emits SkipX, advancing a Reader past a value of X.
+func (e *Builder) skipDecl(el *Field) (decls []ast.Decl) {
+	reader := ast.NewIdent("rd")
+	skip := &ast.FuncDecl{
+		Name: ast.NewIdent(e.skipName(el.typename)),
+		Type: funcType([]*ast.Field{newParam(reader.Name, readerType)}, []*ast.Field{newParam("", errorType)}),
+		Body: &ast.BlockStmt{List: append(e.skipPrim(reader, el), newReturn(newCall(newSel(reader, "Err"))))},
+	}
+	decls = append(decls, skip)
+	if !e.envelope {
+		return
+	}
+
+	decls = append(decls, &ast.FuncDecl{
+		Name: ast.NewIdent(fmt.Sprintf("Skip%s", el.typename)),
+		Type: funcType([]*ast.Field{newParam(reader.Name, readerType)}, []*ast.Field{newParam("", errorType)}),
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.IfStmt{
+				Cond: newCall(newSel(reader, "CheckHash"), ast.NewIdent(fmt.Sprintf("%sSchemaHash", el.typename))),
+				Body: &ast.BlockStmt{List: []ast.Stmt{newCallST(e.skipName(el.typename), reader)}},
+			},
+			newReturn(newCall(newSel(reader, "Err"))),
+		}},
+	})
+	return
+}
+
+func (e *Builder) skipField(reader ast.Expr, s *Field) []ast.Stmt {
+	if _, ok := e.types[s.typename]; ok {
+		return []ast.Stmt{newCallST(e.skipName(s.typename), reader)}
+	}
+	return e.skipPrim(reader, s)
+}
+
+func (e *Builder) skipPrim(reader ast.Expr, s *Field) (stmts []ast.Stmt) {
+	switch {
+	case s.typ.IsPrimitive():
+		stmts = append(stmts, newCallST(newSel(reader, "Skip"), intLit(s.typ.Size())))
+	case (s.typ.IsType(FieldSlice) || s.typ.IsType(FieldString)) && s.sliceType.typ.IsPrimitive():
+		var length ast.Expr = newCall(newSel(reader, "ReadLen"))
+		if size := s.sliceType.typ.Size(); size != 1 {
+			length = newMul(intLit(size), length)
+		}
+		stmts = append(stmts, newCallST(newSel(reader, "Skip"), length))
+	case s.typ.IsType(FieldSlice):
+		i := e.newIdent()
+		length := e.newIdent()
+		stmts = append(stmts, &ast.ForStmt{
+			Init: &ast.AssignStmt{
+				Lhs: []ast.Expr{i, length},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{intLit(0), e.readLen(reader, s)},
+			},
+			Cond: &ast.BinaryExpr{
+				X:  &ast.BinaryExpr{X: i, Op: token.LSS, Y: length},
+				Op: token.LAND,
+				Y:  &ast.BinaryExpr{X: newCall(newSel(reader, "Err")), Op: token.EQL, Y: ast.NewIdent("nil")},
+			},
+			Post: &ast.IncDecStmt{X: i, Tok: token.INC},
+			Body: &ast.BlockStmt{List: e.skipField(reader, s.sliceType)},
+		})
+	case s.typ.IsType(FieldStruct) && s.evolvable:
+		wire := e.newIdent()
+		stmts = append(stmts, &ast.ForStmt{
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("_"), wire},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{newCall(newSel(reader, "ReadTag"))},
+				},
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{X: wire, Op: token.EQL, Y: wireSel(WireEnd)},
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}},
+				},
+				newCallST(newSel(reader, "SkipWire"), wire),
+			}},
+		})
+	case s.typ.IsType(FieldStruct):
+		for _, field := range s.strucFields {
+			bstmts := e.skipField(reader, field.Field)
+			if field.optional {
+				stmts = append(stmts, e.readFlag(reader, bstmts)...)
+			} else {
+				stmts = append(stmts, bstmts...)
+			}
+		}
+	case s.typ.IsType(FieldCustom):
+		// custom coders know no other way to skip
+		if s.cusdec == nil {
+			panic(fmt.Sprintf("custom type %s has no decoder to skip it with", types.ExprString(s.custyp)))
+		}
+		tmp := e.newIdent()
+		stmts = append(stmts, &ast.DeclStmt{Decl: &ast.GenDecl{
+			Tok:   token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{tmp}, Type: s.custyp}},
+		}})
+		stmts = append(stmts, s.cusdec(reader, tmp, s)...)
+		stmts = append(stmts, newAssign("_", tmp))
+	default:
+		panic("wth")
+	}
+	return
+}
+
+// readFlag reads the presence flag of an optional field, running then if
+// it is set.
+func (e *Builder) readFlag(reader ast.Expr, then []ast.Stmt, otherwise ...ast.Stmt) []ast.Stmt {
+	has := e.newIdent()
+	stmt := &ast.IfStmt{Cond: has, Body: &ast.BlockStmt{List: then}}
+	if len(otherwise) > 0 {
+		stmt.Else = &ast.BlockStmt{List: otherwise}
+	}
+	return []ast.Stmt{
+		&ast.DeclStmt{Decl: &ast.GenDecl{
+			Tok:   token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{has}, Type: newIdent(FieldBool.String())}},
+		}},
+		newCallST(newSel(reader, "Copy"), unsafePtr(newPtr(has)), intLit(1)),
+		stmt,
+	}
+}
+
+func viewFunc(view, name string, params, results []*ast.Field) *ast.FuncDecl {
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{List: []*ast.Field{newParam("v", ptrTo(view))}},
+		Name: ast.NewIdent(name),
+		Type: funcType(params, results),
+		Body: &ast.BlockStmt{},
+	}
+}
+
+// viewAccessor is the name of the accessor of a field, capitalized as
+// getters are.
+func viewAccessor(field StructField) string {
+	if unicode.IsUpper(rune(field.strucName[0])) {
+		return field.strucName
+	}
+	return capitalize(field.strucName)
+}
+
+// checkViewNames panics on fields whose accessors collide with each other or
+// with the methods of the view.
+func checkViewNames(el *Field) {
+	names := declNames{"Err": "the Err method"}
+	for _, field := range el.strucFields {
+		owner := fmt.Sprintf("%s.%s", el.typename, field.strucName)
+		names.claim(viewAccessor(field), owner)
+		if field.optional {
+			names.claim("Has"+viewAccessor(field), owner)
+		}
+	}
+}
+
+func (e *Builder) viewDecl(el *Field) (decls []ast.Decl) {
+	checkViewNames(el)
+	view := viewName(el.typename)
+	v := ast.NewIdent("v")
+	data := newSel(v, "data")
+	offs := newSel(v, "offs")
+	verr := newSel(v, "err")
+	nilIdent := ast.NewIdent("nil")
+
+	decls = append(decls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Doc: &ast.CommentGroup{List: append(
+			e.commentGroup(fmt.Sprintf("%s reads the fields of an encoded %s on demand.", view, el.typename)).List,
+			&ast.Comment{Text: "// It indexes the data on first use and is not safe for concurrent use."},
+		)},
+		Specs: []ast.Spec{&ast.TypeSpec{
+			Name: ast.NewIdent(view),
+			Type: &ast.StructType{Fields: &ast.FieldList{List: []*ast.Field{
+				newParam("data", byteSlice),
+				newParam("offs", &ast.ArrayType{Len: intLit(len(el.strucFields)), Elt: newIdent("int")}),
+				newParam("indexed", newIdent("bool")),
+				newParam("err", errorType),
+			}}},
+		}},
+	})
+
+	dataArg := ast.NewIdent("data")
+	ctor := &ast.FuncDecl{
+		Name: ast.NewIdent(fmt.Sprintf("New%s", view)),
+		Type: funcType([]*ast.Field{newParam(dataArg.Name, byteSlice)}, []*ast.Field{newParam("", ptrTo(view))}),
+		Body: &ast.BlockStmt{},
+	}
+	if e.envelope {
+		reader := ast.NewIdent("rd")
+		ctor.Body.List = append(ctor.Body.List,
+			newDef(reader, newCall(newSel("bstruct", "NewReader"), dataArg)),
+			&ast.IfStmt{
+				Cond: &ast.UnaryExpr{Op: token.NOT, X: newCall(newSel(reader, "CheckHash"), ast.NewIdent(fmt.Sprintf("%sSchemaHash", el.typename)))},
+				Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(newPtr(&ast.CompositeLit{
+					Type: ast.NewIdent(view),
+					Elts: []ast.Expr{&ast.KeyValueExpr{Key: ast.NewIdent("err"), Value: newCall(newSel(reader, "Err"))}},
+				}))}},
+			},
+			newAssign(dataArg, &ast.SliceExpr{X: dataArg, Low: newCall(newSel(reader, "Pos"))}),
+		)
+	}
+	ctor.Body.List = append(ctor.Body.List, newReturn(newPtr(&ast.CompositeLit{
+		Type: ast.NewIdent(view),
+		Elts: []ast.Expr{&ast.KeyValueExpr{Key: ast.NewIdent("data"), Value: dataArg}},
+	})))
+	decls = append(decls, ctor)
+
+	errFn := viewFunc(view, "Err", nil, []*ast.Field{newParam("", errorType)})
+	errFn.Doc = e.commentGroup("Err is the first error met reading the fields.")
+	errFn.Body.List = append(errFn.Body.List, newReturn(verr))
+	decls = append(decls, errFn)
+
+	// index records the offset of every field value, -1 if absent
+	reader := ast.NewIdent("rd")
+	index := viewFunc(view, "index", nil, nil)
+	index.Body.List = append(index.Body.List,
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: newSel(v, "indexed"), Op: token.LOR, Y: &ast.BinaryExpr{X: verr, Op: token.NEQ, Y: nilIdent}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{newReturn()}},
+		},
+		newAssign(newSel(v, "indexed"), "true"),
+		newDef(reader, newCall(newSel("bstruct", "NewReader"), data)),
+	)
+	offAt := func(i int) ast.Expr { return newIdx(offs, i) }
+	if el.evolvable {
+		id := e.newIdent()
+		wire := e.newIdent()
+		i := e.newIdent()
+		index.Body.List = append(index.Body.List, &ast.RangeStmt{
+			Key:  i,
+			Tok:  token.DEFINE,
+			X:    offs,
+			Body: &ast.BlockStmt{List: []ast.Stmt{newAssign(newIdx(offs, i), intLit(-1))}},
+		})
+		var clauses []ast.Stmt
+		for i, field := range el.strucFields {
+			var bstmts []ast.Stmt
+			if field.wire() == WireBytes {
+				length := e.newIdent()
+				bstmts = append(bstmts,
+					newDef(length, newCall(newSel(reader, "ReadLen"))),
+					newAssign(offAt(i), newCall(newSel(reader, "Pos"))),
+					newCallST(newSel(reader, "Skip"), length),
+				)
+			} else {
+				bstmts = append(bstmts,
+					newAssign(offAt(i), newCall(newSel(reader, "Pos"))),
+					newCallST(newSel(reader, "SkipWire"), wire),
+				)
+			}
+			clauses = append(clauses, &ast.CaseClause{
+				List: []ast.Expr{intLit(field.id)},
+				Body: []ast.Stmt{&ast.IfStmt{
+					Cond: newCall(newSel(reader, "ExpectWire"), wire, wireSel(field.wire())),
+					Body: &ast.BlockStmt{List: bstmts},
+				}},
+			})
+		}
+		clauses = append(clauses, &ast.CaseClause{Body: []ast.Stmt{newCallST(newSel(reader, "SkipWire"), wire)}})
+		index.Body.List = append(index.Body.List, &ast.ForStmt{
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{id, wire},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{newCall(newSel(reader, "ReadTag"))},
+				},
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{X: wire, Op: token.EQL, Y: wireSel(WireEnd)},
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}},
+				},
+				&ast.SwitchStmt{Tag: id, Body: &ast.BlockStmt{List: clauses}},
+			}},
+		})
+	} else {
+		for i, field := range el.strucFields {
+			bstmts := append([]ast.Stmt{newAssign(offAt(i), newCall(newSel(reader, "Pos")))}, e.skipField(reader, field.Field)...)
+			if field.optional {
+				index.Body.List = append(index.Body.List, e.readFlag(reader, bstmts, newAssign(offAt(i), intLit(-1)))...)
+			} else {
+				index.Body.List = append(index.Body.List, bstmts...)
+			}
+		}
+	}
+	index.Body.List = append(index.Body.List, newAssign(verr, newCall(newSel(reader, "Err"))))
+	decls = append(decls, index)
+
+	for i, field := range el.strucFields {
+		absent := &ast.BinaryExpr{
+			X:  &ast.BinaryExpr{X: verr, Op: token.NEQ, Y: nilIdent},
+			Op: token.LOR,
+			Y:  &ast.BinaryExpr{X: offAt(i), Op: token.LSS, Y: intLit(0)},
+		}
+		at := &ast.SliceExpr{X: data, Low: offAt(i)}
+		name := viewAccessor(field)
+
+		if field.optional {
+			has := viewFunc(view, "Has"+name, nil, []*ast.Field{newParam("", newIdent("bool"))})
+			has.Body.List = append(has.Body.List,
+				newCallST(newSel(v, "index")),
+				newReturn(&ast.UnaryExpr{Op: token.NOT, X: &ast.ParenExpr{X: absent}}),
+			)
+			decls = append(decls, has)
+		}
+
+		if _, ok := e.types[field.typename]; ok && field.typ.IsType(FieldStruct) {
+			// nested views share the data, absent fields have none
+			nested := viewName(field.typename)
+			fn := viewFunc(view, name, nil, []*ast.Field{newParam("", ptrTo(nested))})
+			fn.Body.List = append(fn.Body.List,
+				newCallST(newSel(v, "index")),
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{X: verr, Op: token.NEQ, Y: nilIdent},
+					Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(newPtr(&ast.CompositeLit{
+						Type: ast.NewIdent(nested),
+						Elts: []ast.Expr{&ast.KeyValueExpr{Key: ast.NewIdent("err"), Value: verr}},
+					}))}},
+				},
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{X: offAt(i), Op: token.LSS, Y: intLit(0)},
+					Body: &ast.BlockStmt{List: []ast.Stmt{newReturn(nilIdent)}},
+				},
+				newReturn(newPtr(&ast.CompositeLit{
+					Type: ast.NewIdent(nested),
+					Elts: []ast.Expr{&ast.KeyValueExpr{Key: ast.NewIdent("data"), Value: at}},
+				})),
+			)
+			decls = append(decls, fn)
+			continue
+		}
+
+		result := ast.NewIdent("r")
+		fn := viewFunc(view, name, nil, []*ast.Field{newParam(result.Name, e.typWrap(field.Field))})
+		fn.Body.List = append(fn.Body.List,
+			newCallST(newSel(v, "index")),
+			&ast.IfStmt{Cond: absent, Body: &ast.BlockStmt{List: []ast.Stmt{newReturn()}}},
+			newDef(reader, newCall(newSel("bstruct", "NewReader"), at)),
+		)
+		fn.Body.List = append(fn.Body.List, e.decField(reader, result, field.Field)...)
+		fn.Body.List = append(fn.Body.List,
+			newAssign(verr, newCall(newSel(reader, "Err"))),
+			newReturn(),
+		)
+		decls = append(decls, fn)
+	}
+	return
+}
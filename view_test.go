@@ -0,0 +1,36 @@
+package bstruct
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewNames(t *testing.T) {
+	for _, names := range [][]string{{"Err"}, {"a", "A"}, {"X", "HasX"}, {"HasX", "X"}} {
+		e := NewBuilder().View(true)
+		f := New(FieldStruct).Reg(e, "Msg")
+		for _, name := range names {
+			f.Add(name, "", name == "X", New(FieldBool))
+		}
+		require.Panics(t, func() { e.Process() })
+	}
+
+	e := NewBuilder().View(true)
+	New(FieldStruct).Reg(e, "Msg").Add("Err", "", false, New(FieldBool))
+	require.PanicsWithValue(t, "Err of Msg.Err is taken by the Err method", func() { e.Process() })
+}
+
+func TestSkipOption(t *testing.T) {
+	e := NewBuilder()
+	New(FieldStruct).Reg(e, "Msg").Add("A", "", false, NewCustom(newIdent("int"), nil, nil))
+	e.Process()
+	buf := new(strings.Builder)
+	require.NoError(t, e.Print(buf, "main"))
+	require.NotContains(t, buf.String(), "SkipMsg")
+
+	e = NewBuilder().Skip(true)
+	New(FieldStruct).Reg(e, "Msg").Add("A", "", false, NewCustom(newIdent("int"), nil, nil))
+	require.Panics(t, func() { e.Process() })
+}